@@ -21,10 +21,32 @@ interface CandidateMessage {
 interface TimeoutMessage {
    type: 'timeout';
    message: string;
+   closesInSec?: number;
 }
 
-type SignalingMessage = RoleMessage | OfferMessage | AnswerMessage | CandidateMessage | TimeoutMessage;
+interface RoomClosedMessage {
+   type: 'room-closed';
+   message: string;
+}
 
+interface ErrorMessage {
+   type: 'error';
+   data: { code: string; message: string; for: string };
+}
+
+// chat through the server when the data channel is not open (ICE failed, strict firewall)
+interface RelayMessage {
+   type: 'relay';
+   data: { text: string };
+}
+
+type SignalingMessage = RoleMessage | OfferMessage | AnswerMessage | CandidateMessage | TimeoutMessage | RoomClosedMessage | ErrorMessage | RelayMessage;
+
+interface RoomResponse {
+   roomId: string;
+   clientId: string;
+   iceServers?: RTCIceServer[];
+}
 
 export class WebRtcConnection {
    private apiBase: string
@@ -32,36 +54,40 @@ export class WebRtcConnection {
    private peerConn: RTCPeerConnection;
    private dataChannel: RTCDataChannel | null = null
    private ws: WebSocket | null = null
+   // fallback when a proxy blocks the WebSocket: messages arrive as Server-Sent Events, ours are POSTed
+   private events: EventSource | null = null
+   private messagesUrl = ""
    // private isOfferer: boolean = false
 
    constructor(apiBase: string, wsBase: string) {
-      this.peerConn = new RTCPeerConnection({
-         iceServers: [
-            { urls: "stun:stun.l.google.com:19302" } // free public STUN
-         ]
-      })
+      // ICE servers (STUN + short lived TURN credentials) come from the create/join response
+      this.peerConn = new RTCPeerConnection()
       this.apiBase = apiBase
       this.wsBase = wsBase
    }
 
    public async createRoom() {
       const res = await fetch(`${this.apiBase}/api/rooms/create`, { method: "POST" })
-      const data: { roomId: string, clientId: string } = await res.json()
+      const data: RoomResponse = await res.json()
+      this.peerConn.setConfiguration({ iceServers: data.iceServers })
       this.connectWebSocket(data.roomId, data.clientId)
       return data
    }
 
    public async joinRoom(roomId: string) {
       const res = await fetch(`${this.apiBase}/api/rooms/join?roomId=${roomId}`, { method: "POST" })
-      const data: { roomId: string, clientId: string } = await res.json()
+      const data: RoomResponse = await res.json()
+      this.peerConn.setConfiguration({ iceServers: data.iceServers })
       this.connectWebSocket(data.roomId, data.clientId)
       return data
    }
 
    private connectWebSocket(roomId: string, clientId: string) {
+      let opened = false
       this.ws = new WebSocket(`${this.wsBase}/ws?roomId=${roomId}&clientId=${clientId}`)
 
       this.ws.onopen = () => {
+         opened = true
          this.log("websocket connected ✅");
          this.initWebRtc()
       }
@@ -70,16 +96,45 @@ export class WebRtcConnection {
          const msg = JSON.parse(e.data)
          this.handleSignalingMessage(msg);
       }
+
+      this.ws.onclose = () => {
+         if (!opened) {
+            this.ws = null
+            this.connectEventStream(roomId, clientId)
+         }
+      }
+   }
+
+   private connectEventStream(roomId: string, clientId: string) {
+      this.messagesUrl = `${this.apiBase}/api/rooms/${roomId}/messages?clientId=${clientId}`
+      this.events = new EventSource(this.messagesUrl)
+      let opened = false
+
+      this.events.onopen = () => {
+         if (opened) return // EventSource reconnected on its own
+         opened = true
+         this.log("websocket blocked, signaling over server-sent events ✅");
+         this.initWebRtc()
+      }
+
+      this.events.onmessage = (e) => {
+         const msg = JSON.parse(e.data)
+         this.handleSignalingMessage(msg);
+      }
    }
 
-   private sendSignalToWS(message:SignalingMessage) {
+   private sendSignal(message: SignalingMessage) {
+      if (this.events) {
+         fetch(this.messagesUrl, { method: "POST", body: JSON.stringify(message) })
+         return
+      }
       this.ws?.send(JSON.stringify(message))
    }
 
    private initWebRtc() {
       this.peerConn.onicecandidate = (e) => {
          if (e.candidate) {
-            this.sendSignalToWS({ type: "candidate", data: e.candidate })
+            this.sendSignal({ type: "candidate", data: e.candidate })
          }
       }
 
@@ -88,6 +143,12 @@ export class WebRtcConnection {
          this.setupDataChannel()
       }
 
+      this.peerConn.oniceconnectionstatechange = () => {
+         if (this.peerConn.iceConnectionState === "failed") {
+            this.log("⚠️ peer-to-peer failed, chat goes through the server relay")
+         }
+      }
+
    }
 
    private handleSignalingMessage(msg: SignalingMessage) {
@@ -99,7 +160,7 @@ export class WebRtcConnection {
                   this.setupDataChannel()
                   this.peerConn.createOffer().then((offer) => {
                      this.peerConn.setLocalDescription(offer)
-                     this.sendSignalToWS({ type: "offer", data: offer })
+                     this.sendSignal({ type: "offer", data: offer })
                   })
                }
             }
@@ -111,7 +172,7 @@ export class WebRtcConnection {
 
                this.peerConn.createAnswer().then((answer) => {
                   this.peerConn.setLocalDescription(answer)
-                  this.sendSignalToWS({ type: "answer", data: answer })
+                  this.sendSignal({ type: "answer", data: answer })
                })
             }
             break;
@@ -134,6 +195,26 @@ export class WebRtcConnection {
             }
             break;
 
+         case "error":
+            {
+               this.log("⚠️ server refused", msg.data.for, "-", msg.data.code, msg.data.message);
+            }
+            break;
+
+         case "relay":
+            {
+               this.log("📩 Received (relay):", msg.data.text)
+            }
+            break;
+
+         case "room-closed":
+            {
+               this.log("🗑️", msg.message);
+               this.ws?.close()
+               this.events?.close()
+            }
+            break;
+
          default:
             break;
       }
@@ -147,6 +228,9 @@ export class WebRtcConnection {
    public sendWebRTCmessage(msg: string) {
       if (this.dataChannel?.readyState === "open") {
          this.dataChannel.send(msg)
+      } else if (this.ws?.readyState === WebSocket.OPEN || this.events?.readyState === EventSource.OPEN) {
+         // rate and size limited by the server, fine for chat
+         this.sendSignal({ type: "relay", data: { text: msg } })
       }
    }
 
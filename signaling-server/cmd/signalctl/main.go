@@ -0,0 +1,327 @@
+/*
+Signalctl is the operator's view of a running signaling server, through the admin API.
+
+	signalctl [-server URL] [-key KEY] [-o table|json] <command> [arguments]
+
+	rooms                                  every room with its slot counts
+	room <roomId>                          a room's clients, their state and role
+	stats                                  /api/rooms/stats
+	kick [-reason r] <roomId> <clientId>   disconnect a client and release its slot
+	close [-reason r] <roomId>             close a room
+	announce [-room roomId] <message>      message to one room or all of them
+	events [-room roomId] [-types t1,t2]   follow the hub's events until interrupted
+
+The server and key default to SIGNALCTL_SERVER and SIGNALCTL_API_KEY. Reading needs an
+operator key, kick/close/announce an admin key. events needs a server with the event
+stream (/api/events), older ones answer it with a 404.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"signaling-server-webrtc/sdk"
+	"signaling-server-webrtc/utils"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *sdk.Client, out *output, args []string) error
+}
+
+var commands = map[string]command{
+	"rooms":    {"rooms", listRooms},
+	"room":     {"room <roomId>", showRoom},
+	"stats":    {"stats", showStats},
+	"kick":     {"kick [-reason r] <roomId> <clientId>", kick},
+	"close":    {"close [-reason r] <roomId>", closeRoom},
+	"announce": {"announce [-room roomId] <message>", announce},
+	"events":   {"events [-room roomId] [-types t1,t2]", events},
+}
+
+func main() {
+	server := flag.String("server", envOr("SIGNALCTL_SERVER", "http://localhost:1337"), "server base URL")
+	key := flag.String("key", utils.GetEnv("SIGNALCTL_API_KEY"), "API key (operator or admin)")
+	format := flag.String("o", "table", "output format: table or json")
+	timeout := flag.Duration("timeout", 10*time.Second, "per request timeout")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "signalctl: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "signalctl: unknown output format %q\n", *format)
+		os.Exit(2)
+	}
+
+	c := sdk.New(*server)
+	c.APIKey = *key
+	c.HTTP = &http.Client{
+		Timeout: *timeout,
+		// a cluster redirects room requests to their owner, Go drops the key when the host changes
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("stopped after 3 redirects")
+			}
+			req.Header.Set("Authorization", via[0].Header.Get("Authorization"))
+			return nil
+		},
+	}
+
+	out := &output{json: *format == "json"}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cmd.run(ctx, c, out, flag.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "usage: signalctl", cmd.usage)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "signalctl:", err)
+		var httpErr *sdk.HTTPError
+		if errors.As(err, &httpErr) && (httpErr.Status == http.StatusUnauthorized || httpErr.Status == http.StatusForbidden) {
+			fmt.Fprintln(os.Stderr, "signalctl: pass an operator/admin key with -key or SIGNALCTL_API_KEY")
+		}
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: signalctl [flags] <command> [arguments]\n\ncommands:")
+	for _, name := range []string{"rooms", "room", "stats", "kick", "close", "announce", "events"} {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nflags:")
+	flag.PrintDefaults()
+}
+
+func envOr(name, fallback string) string {
+	if v := utils.GetEnv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+var errUsage = errors.New("wrong number of arguments")
+
+// args parses a command's own flags and checks the number of positional arguments
+func args(fs *flag.FlagSet, argv []string, min, max int) ([]string, error) {
+	if err := fs.Parse(argv); err != nil {
+		return nil, err
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+func listRooms(ctx context.Context, c *sdk.Client, out *output, argv []string) error {
+	if _, err := args(flag.NewFlagSet("rooms", flag.ContinueOnError), argv, 0, 0); err != nil {
+		return err
+	}
+	rooms, err := c.AdminRooms(ctx)
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.JSON(rooms)
+	}
+
+	t := out.Table("ROOM", "TOPOLOGY", "NODE", "CONNECTED", "RESERVED", "RECONNECTING", "AGE", "WAITING")
+	for _, room := range rooms {
+		states := map[string]int{}
+		for _, client := range room.Clients {
+			states[client.State]++
+		}
+		t.Row(room.RoomID, topology(room), dash(room.Node),
+			states[sdk.ClientConnected], states[sdk.ClientReserved], states[sdk.ClientReconnecting],
+			since(&room.CreatedAt), since(room.WaitingSince))
+	}
+	return t.Flush()
+}
+
+func showRoom(ctx context.Context, c *sdk.Client, out *output, argv []string) error {
+	a, err := args(flag.NewFlagSet("room", flag.ContinueOnError), argv, 1, 1)
+	if err != nil {
+		return err
+	}
+	room, err := c.AdminRoom(ctx, a[0])
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.JSON(room)
+	}
+
+	fmt.Fprintf(out, "Room:      %s\n", room.RoomID)
+	fmt.Fprintf(out, "Topology:  %s\n", topology(room))
+	fmt.Fprintf(out, "Node:      %s\n", dash(room.Node))
+	fmt.Fprintf(out, "Created:   %s (%s ago)\n", room.CreatedAt.Local().Format(time.DateTime), since(&room.CreatedAt))
+	if room.WaitingSince != nil {
+		fmt.Fprintf(out, "Waiting:   %s for a peer\n", since(room.WaitingSince))
+	}
+	if room.Private {
+		fmt.Fprintln(out, "Private:   yes")
+	}
+	fmt.Fprintln(out)
+
+	t := out.Table("CLIENT", "STATE", "ROLE", "NODE", "PENDING")
+	for _, client := range room.Clients {
+		t.Row(client.ClientID, client.State, dash(client.Role), dash(client.Node), client.Pending)
+	}
+	return t.Flush()
+}
+
+func showStats(ctx context.Context, c *sdk.Client, out *output, argv []string) error {
+	if _, err := args(flag.NewFlagSet("stats", flag.ContinueOnError), argv, 0, 0); err != nil {
+		return err
+	}
+	stats, err := c.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	if out.json {
+		return out.JSON(stats)
+	}
+
+	t := out.Table("ROOM", "CLIENTS", "PRESENTER", "VIEWERS", "RELAY ALLOCATIONS", "RELAY BYTES")
+	for _, room := range stats.Rooms {
+		viewers, allocations, relayBytes := "-", "-", "-"
+		if room.Viewers != nil {
+			viewers = fmt.Sprint(*room.Viewers)
+		}
+		if room.Relay != nil {
+			allocations = fmt.Sprint(room.Relay.Allocations)
+			relayBytes = fmt.Sprintf("%d/%d", room.Relay.BytesSent, room.Relay.BytesReceived)
+		}
+		t.Row(room.RoomID, strings.Join(room.Clients, ","), dash(room.Presenter), viewers, allocations, relayBytes)
+	}
+	if err := t.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\n%d rooms\n", stats.TotalRooms)
+	return nil
+}
+
+func kick(ctx context.Context, c *sdk.Client, out *output, argv []string) error {
+	fs := flag.NewFlagSet("kick", flag.ContinueOnError)
+	reason := fs.String("reason", "", "told to the client before it is disconnected")
+	a, err := args(fs, argv, 2, 2)
+	if err != nil {
+		return err
+	}
+	if err := c.Kick(ctx, a[0], a[1], *reason); err != nil {
+		return err
+	}
+	return out.Done(map[string]string{"roomId": a[0], "clientId": a[1], "status": "kicked"},
+		"kicked %s from room %s", a[1], a[0])
+}
+
+func closeRoom(ctx context.Context, c *sdk.Client, out *output, argv []string) error {
+	fs := flag.NewFlagSet("close", flag.ContinueOnError)
+	reason := fs.String("reason", "", "told to the room's clients")
+	a, err := args(fs, argv, 1, 1)
+	if err != nil {
+		return err
+	}
+	if err := c.CloseRoom(ctx, a[0], *reason); err != nil {
+		return err
+	}
+	return out.Done(map[string]string{"roomId": a[0], "status": "closed"}, "closed room %s", a[0])
+}
+
+func announce(ctx context.Context, c *sdk.Client, out *output, argv []string) error {
+	fs := flag.NewFlagSet("announce", flag.ContinueOnError)
+	room := fs.String("room", "", "only this room, all rooms otherwise")
+	a, err := args(fs, argv, 1, -1)
+	if err != nil {
+		return err
+	}
+	n, err := c.Announce(ctx, *room, strings.Join(a, " "))
+	if err != nil {
+		return err
+	}
+	return out.Done(map[string]int{"rooms": n}, "announced to %d rooms", n)
+}
+
+func events(ctx context.Context, c *sdk.Client, out *output, argv []string) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	room := fs.String("room", "", "only this room")
+	eventTypes := fs.String("types", "", "only these comma separated types, e.g. client-connected,role-assigned")
+	if _, err := args(fs, argv, 0, 0); err != nil {
+		return err
+	}
+	var filter []string
+	if *eventTypes != "" {
+		filter = strings.Split(*eventTypes, ",")
+	}
+
+	err := c.Events(ctx, *room, filter, func(e sdk.Event) error {
+		if out.json {
+			return out.JSONLine(e)
+		}
+		line := fmt.Sprintf("%s  %-10s %-20s", e.Time.Local().Format("15:04:05.000"), dash(e.Node), e.Type)
+		if e.RoomID != "" {
+			line += " room=" + e.RoomID
+		}
+		if e.ClientID != "" {
+			line += " client=" + e.ClientID
+		}
+		keys := make([]string, 0, len(e.Data))
+		for k := range e.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			line += fmt.Sprintf(" %s=%v", k, e.Data[k])
+		}
+		_, err := fmt.Fprintln(out, line)
+		return err
+	})
+	if ctx.Err() != nil {
+		return nil // interrupted
+	}
+	if err == nil {
+		err = errors.New("the server closed the event stream")
+	}
+	return err
+}
+
+func topology(room sdk.AdminRoom) string {
+	if room.Options.Topology == "" {
+		return "mesh"
+	}
+	return room.Options.Topology
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func since(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return time.Since(*t).Round(time.Second).String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// output prints tables for people and indented JSON for jq
+type output struct {
+	json bool
+}
+
+func (o *output) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+
+func (o *output) JSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// JSONLine writes v on one line, for streams
+func (o *output) JSONLine(v any) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
+
+// Done reports a finished action, v in JSON mode
+func (o *output) Done(v any, format string, a ...any) error {
+	if o.json {
+		return o.JSON(v)
+	}
+	_, err := fmt.Printf(format+"\n", a...)
+	return err
+}
+
+type table struct {
+	w *tabwriter.Writer
+}
+
+func (o *output) Table(header ...string) *table {
+	t := &table{w: tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)}
+	fmt.Fprintln(t.w, strings.Join(header, "\t"))
+	return t
+}
+
+func (t *table) Row(cells ...any) {
+	s := make([]string, len(cells))
+	for i, c := range cells {
+		s[i] = fmt.Sprint(c)
+	}
+	fmt.Fprintln(t.w, strings.Join(s, "\t"))
+}
+
+func (t *table) Flush() error { return t.w.Flush() }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/handoff"
+	"signaling-server-webrtc/srv"
+)
+
+// takeOver restores the predecessor's rooms into h (before h.Run) and returns its listener
+func takeOver(h *pkg.Hub, t handoff.Transfer) (net.Listener, error) {
+	var snapshot pkg.Snapshot
+	if err := json.Unmarshal(t.State, &snapshot); err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	ln, err := net.FileListener(t.Listener)
+	t.Listener.Close()
+	if err != nil {
+		for _, f := range t.Conns {
+			f.Close()
+		}
+		return nil, err
+	}
+
+	conns := make([]*websocket.Conn, len(t.Conns))
+	for i, f := range t.Conns {
+		c, err := net.FileConn(f)
+		f.Close()
+		if err == nil {
+			conns[i], err = srv.ResumeWS(c)
+		}
+		if err != nil {
+			log.Printf("Handoff: connection %d not resumed: %s\n", i, err)
+		}
+	}
+	h.Restore(snapshot, conns)
+	log.Printf("Took over from node %s: %d rooms, %d WebSockets\n", snapshot.Node, len(snapshot.Rooms), len(conns))
+	return ln, nil
+}
+
+// handOver stops serving and packs up everything for the successor. stopUDP frees the STUN/TURN ports.
+func handOver(h *pkg.Hub, server *http.Server, ln net.Listener, stopUDP func()) (handoff.Transfer, error) {
+	lf, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		return handoff.Transfer{}, err
+	}
+
+	// no new connections from here on, they wait in the backlog of the listener for the successor
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Handoff: in-flight requests cut off: %s\n", err)
+	}
+	stopUDP()
+
+	ho := h.Detach()
+
+	// renumber the connections that made it, the others have to reconnect
+	t := handoff.Transfer{Listener: lf}
+	index := make(map[int]int)
+	for i, c := range ho.Conns {
+		f, err := srv.ConnFile(c)
+		if err != nil {
+			log.Printf("Handoff: connection %d not handed over: %s\n", i, err)
+			continue
+		}
+		index[i] = len(t.Conns)
+		t.Conns = append(t.Conns, f)
+	}
+	for _, room := range ho.Snapshot.Rooms {
+		for j := range room.Clients {
+			cs := &room.Clients[j]
+			if cs.Conn < 0 {
+				continue
+			}
+			if i, ok := index[cs.Conn]; ok {
+				cs.Conn = i
+			} else {
+				cs.Conn, cs.Held = -1, true
+			}
+		}
+	}
+
+	t.State, err = json.Marshal(ho.Snapshot)
+	if err != nil {
+		t.Close()
+		return handoff.Transfer{}, err
+	}
+	log.Printf("Handing over %d rooms, %d WebSockets\n", len(ho.Snapshot.Rooms), len(t.Conns))
+	return t, nil
+}
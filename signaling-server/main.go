@@ -2,20 +2,30 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/pion/webrtc/v4"
 	"github.com/rs/cors"
 
 	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/affinity"
+	"signaling-server-webrtc/pkg/auth"
+	"signaling-server-webrtc/pkg/bot"
+	"signaling-server-webrtc/pkg/bus"
 	"signaling-server-webrtc/pkg/handlers"
+	"signaling-server-webrtc/pkg/handoff"
+	"signaling-server-webrtc/pkg/ice"
+	"signaling-server-webrtc/pkg/sfu"
+	"signaling-server-webrtc/pkg/store"
 	"signaling-server-webrtc/srv"
 	"signaling-server-webrtc/utils"
 )
@@ -26,38 +36,223 @@ func init() {
 }
 
 func main() {
+	// room reservations, in memory or shared between instances (ROOM_STORE=redis)
+	roomStore, err := store.FromEnv()
+	if err != nil {
+		log.Fatalf("FATAL: room store setup failed: %s", err)
+	}
+	defer roomStore.Close()
+
+	// messages for sockets held by other instances (BUS=redis), a no-op with a single instance
+	messageBus, err := bus.FromEnv()
+	if err != nil {
+		log.Fatalf("FATAL: message bus setup failed: %s", err)
+	}
+	defer messageBus.Close()
+	log.Printf("Node id: %s\n", messageBus.Node())
+
 	// this hub denotes a room where clients will be added and removed by using go routines.
-	h := pkg.NewHub() // this will create 3 new channels for register, unregister, broadcast
-	go h.Run()        // this is going to run concurrently and listen to all the data made available in that channel
+	h := pkg.NewHub(roomStore, messageBus) // this will create 3 new channels for register, unregister, broadcast
+
+	// zero downtime restarts (Linux): a new process takes the listener and the live WebSockets over,
+	// before binding anything else so the STUN/TURN ports are free by then
+	handoffPath := utils.GetEnv("HANDOFF_SOCKET")
+	var inherited *handoff.Transfer
+	if handoffPath != "" {
+		if utils.GetEnv("ENV") == "local" || utils.GetEnv("TLS_MODE") != "" {
+			log.Fatalf("FATAL: HANDOFF_SOCKET needs plain HTTP, terminate TLS in front of the server")
+		}
+		t, err := handoff.Take(handoffPath)
+		switch {
+		case err == nil:
+			inherited = &t
+		case errors.Is(err, handoff.ErrNoPredecessor):
+			log.Println("No running server to take over from, starting fresh")
+		default:
+			log.Fatalf("FATAL: handoff failed: %s", err)
+		}
+	}
+
+	// pins each room to one node (CLUSTER_MEMBERS), nil routes nothing
+	router, err := affinity.FromEnv(messageBus.Node(), h.LookupRoom)
+	if err != nil {
+		log.Fatalf("FATAL: cluster routing setup failed: %s", err)
+	}
+	h.SetRoomOwnership(router.Owns)
+
+	iceConfig := ice.ConfigFromEnv() // STUN/TURN servers handed out to clients
+
+	// optional TURN relay in the same process, it only accepts credentials minted for a room reservation
+	var turnServer *ice.TURNServer
+	if turnAddr := utils.GetEnv("TURN_ADDR"); turnAddr != "" {
+		publicIP := net.ParseIP(utils.GetEnv("TURN_PUBLIC_IP"))
+		if publicIP == nil {
+			log.Fatalf("FATAL: 'TURN_PUBLIC_IP' must be set to a valid IP when TURN_ADDR is set")
+		}
+		if iceConfig.SharedSecret == "" {
+			iceConfig.SharedSecret = utils.GenerateShortID(32) // nobody outside this process needs it
+		}
+
+		var err error
+		turnServer, err = ice.ListenTURN(ice.TURNConfig{
+			Addr:               turnAddr,
+			PublicIP:           publicIP,
+			Realm:              utils.GetEnv("TURN_REALM"),
+			MaxBytesPerSec:     utils.GetEnvInt("TURN_MAX_BYTES_PER_SEC", 0),
+			MaxLifetime:        utils.GetEnvDuration("TURN_MAX_LIFETIME", 0),
+			MaxRoomAllocations: utils.GetEnvInt("TURN_ROOM_MAX_ALLOCATIONS", 0),
+		}, iceConfig.SharedSecret, h)
+		if err != nil {
+			log.Fatalf("FATAL: TURN server failed to start: %s", err)
+		}
+		h.SetRelayUsageSource(turnServer.RoomUsage)
+
+		host := utils.GetEnv("TURN_PUBLIC_HOST")
+		if host == "" {
+			host = publicIP.String()
+		}
+		_, port, _ := net.SplitHostPort(turnAddr)
+		hostPort := net.JoinHostPort(host, port)
+		iceConfig.AdvertiseTURN("turn:"+hostPort+"?transport=udp", "turn:"+hostPort+"?transport=tcp")
+	}
+
+	// optional STUN server in the same process, advertised to clients when its public host is known
+	var stunServer *ice.STUNServer
+	if stunAddr := utils.GetEnv("STUN_ADDR"); stunAddr != "" {
+		var err error
+		stunServer, err = ice.ListenSTUN(stunAddr)
+		if err != nil {
+			log.Fatalf("FATAL: STUN server failed to start: %s", err)
+		}
+		stunServer.Serve()
+
+		if host := utils.GetEnv("STUN_PUBLIC_HOST"); host != "" {
+			_, port, _ := net.SplitHostPort(stunAddr)
+			iceConfig.AdvertiseSTUN("stun:" + net.JoinHostPort(host, port))
+		} else {
+			log.Println("STUN_PUBLIC_HOST not set, embedded STUN server is not advertised to clients")
+		}
+	}
+
+	// server side media for rooms created with topology "sfu", on by default since it needs no external service
+	if utils.GetEnv("SFU_ENABLED") != "false" {
+		s, err := sfu.New(sfu.Config{
+			ICEServers: []webrtc.ICEServer{{URLs: iceConfig.STUNURLs}},
+			PublicIP:   utils.GetEnv("SFU_PUBLIC_IP"),
+			UDPPortMin: uint16(utils.GetEnvInt("SFU_UDP_PORT_MIN", 0)),
+			UDPPortMax: uint16(utils.GetEnvInt("SFU_UDP_PORT_MAX", 0)),
+			WHEPVideo:  utils.GetEnv("WHEP_VIDEO_CODEC"),
+		})
+		if err != nil {
+			log.Fatalf("FATAL: SFU setup failed: %s", err)
+		}
+		h.SetSFU(s)
+	}
+
+	// headless peers the server can put into a room (echo bot, ...)
+	spawner := bot.NewSpawner(h, []webrtc.ICEServer{{URLs: iceConfig.STUNURLs}})
+
+	var ln net.Listener
+	if inherited != nil {
+		ln, err = takeOver(h, *inherited)
+		if err != nil {
+			log.Fatalf("FATAL: handoff restore failed: %s", err)
+		}
+	}
+
+	// SNAPSHOT_FILE: the rooms of a single instance survive a restart or crash
+	snapshotPath := utils.GetEnv("SNAPSHOT_FILE")
+	if snapshotPath != "" && inherited == nil {
+		if n, err := h.LoadSnapshot(snapshotPath); err != nil {
+			log.Printf("Snapshot %s not restored, starting without it: %s\n", snapshotPath, err)
+		} else if n > 0 {
+			log.Printf("Restored %d rooms from %s\n", n, snapshotPath)
+		}
+	}
+
+	go h.Run() // this is going to run concurrently and listen to all the data made available in that channel
+
+	stopSnapshots := make(chan struct{})
+	if snapshotPath != "" {
+		go h.SaveSnapshots(snapshotPath, utils.GetEnvDuration("SNAPSHOT_INTERVAL", 30*time.Second), stopSnapshots)
+	}
+
+	// API keys with roles, stats and the admin API are not for anonymous callers
+	keys, err := auth.FromEnv()
+	if err != nil {
+		log.Fatalf("FATAL: API keys: %s", err)
+	}
+	if keys.Len() == 0 {
+		log.Println("No API_KEYS/ADMIN_TOKEN set, stats are limited to a room's own clients and the admin API is closed")
+	}
+	operator, admin := keys.Require(auth.Operator), keys.Require(auth.Admin)
 
 	r := mux.NewRouter()
 
 	r.HandleFunc("/api/health", handlers.HandleHealthCheck("Signaling Server")).Methods("GET")
 
-	r.HandleFunc("/api/rooms/create", handlers.HandleCreateRoom(h)).Methods("POST")
-	r.HandleFunc("/api/rooms/join", handlers.HandleJoinRoom(h)).Methods("POST")
+	r.HandleFunc("/api/ice-servers", handlers.HandleICEServers(h, iceConfig)).Methods("GET")
+
+	r.Handle("/api/rooms/create", router.NewRoom(handlers.HandleCreateRoom(h, iceConfig))).Methods("POST")
+	r.Handle("/api/rooms/join", router.Room(affinity.QueryRoomID, handlers.HandleJoinRoom(h, iceConfig))).Methods("POST")
 	// r.HandleFunc("/api/rooms/leave", handlers.HandleLeaveRoom(h)).Methods("POST")
-	r.HandleFunc("/api/rooms/stats", handlers.HandleRoomStats(h)).Methods("GET")
+	r.Handle("/api/rooms/stats", router.Room(affinity.QueryRoomID,
+		keys.RequireOr(auth.Operator, handlers.RoomParticipant(h))(handlers.HandleRoomStats(h)))).Methods("GET")
+
+	r.HandleFunc("/api/bots", handlers.HandleListBots()).Methods("GET")
+	// only for the room's own clients (roomId + clientId) or operators
+	r.Handle("/api/bots/spawn", router.Room(affinity.QueryRoomID,
+		keys.RequireOr(auth.Operator, handlers.RoomParticipant(h))(handlers.HandleSpawnBot(h, spawner)))).Methods("POST")
+	r.Handle("/api/selftest", router.NewRoom(handlers.HandleSelfTest(h, iceConfig, spawner,
+		utils.GetEnvDuration("SELFTEST_MAX_DURATION", 5*time.Minute)))).Methods("POST")
+
+	// WHIP ingest / WHEP playback for broadcast tools, sessions live in "sfu" rooms
+	r.Handle("/api/whip/{roomId}", router.Room(affinity.PathRoomID, handlers.HandleWHIPOffer(h, iceConfig))).Methods("POST")
+	r.Handle("/api/whep/{roomId}", router.Room(affinity.PathRoomID, handlers.HandleWHEPOffer(h, iceConfig))).Methods("POST")
+	r.Handle("/api/{kind:whip|whep}/{roomId}/{clientId}", router.Room(affinity.PathRoomID, handlers.HandleHTTPSessionPatch(h))).Methods("PATCH")
+	r.Handle("/api/{kind:whip|whep}/{roomId}/{clientId}", router.Room(affinity.PathRoomID, handlers.HandleHTTPSessionDelete(h))).Methods("DELETE")
+
+	// operators look, admins act
+	a := r.PathPrefix("/api/admin").Subrouter()
+	a.Handle("/rooms", operator(handlers.HandleAdminListRooms(h))).Methods("GET")
+	a.Handle("/rooms/{roomId}", operator(router.Room(affinity.PathRoomID, handlers.HandleAdminRoom(h)))).Methods("GET")
+	a.Handle("/rooms/{roomId}", admin(router.Room(affinity.PathRoomID, handlers.HandleAdminCloseRoom(h)))).Methods("DELETE")
+	a.Handle("/rooms/{roomId}/clients/{clientId}/kick", admin(router.Room(affinity.PathRoomID, handlers.HandleAdminKick(h)))).Methods("POST")
+	a.Handle("/rooms/{roomId}/announce", admin(router.Room(affinity.PathRoomID, handlers.HandleAdminAnnounce(h)))).Methods("POST")
+	a.Handle("/announce", admin(handlers.HandleAdminAnnounce(h))).Methods("POST")
+
+	// what the hub does, live: SSE for curl and browsers, or a WebSocket
+	r.Handle("/api/events", operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.ServeEventsSSE(h, w, r)
+	}))).Methods("GET")
+	r.Handle("/api/events/ws", operator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.ServeEventsWS(h, w, r)
+	}))).Methods("GET")
 
-	r.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+	r.Handle("/ws", router.Room(affinity.QueryRoomID, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		srv.ServeWS(h, w, r)
-	}).Methods("GET")
+	}))).Methods("GET")
 	// The '/ws' route listens for WebSocket upgrade requests over HTTP GET.
 	// Clients connect to this endpoint to establish a persistent WebSocket connection.
 
-	// Configure CORS
-	var allowedOrigins []string
-	originsStr := os.Getenv("CORS_ALLOWED_ORIGINS")
+	// same signaling for networks that block WebSockets: SSE down, POST up
+	r.Handle("/api/rooms/{roomId}/messages", router.Room(affinity.PathRoomID, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.ServeStream(h, w, r)
+	}))).Methods("GET")
+	r.Handle("/api/rooms/{roomId}/messages", router.Room(affinity.PathRoomID, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.PostMessage(h, w, r)
+	}))).Methods("POST")
 
-	if originsStr == "" {
+	// Configure CORS
+	allowedOrigins := utils.GetEnvList("CORS_ALLOWED_ORIGINS")
+	if len(allowedOrigins) == 0 {
 		log.Fatalf("FATAL: 'CORS_ALLOWED_ORIGINS' environment variable not set")
-	} else {
-		allowedOrigins = strings.Split(originsStr, ",")
 	}
 	c := cors.New(cors.Options{
 		AllowedOrigins:   allowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization", "Accept"},
+		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "Accept", "X-API-Key"},
+		ExposedHeaders:   []string{"Location", "Link"}, // WHIP/WHEP session URL and ICE servers
 		AllowCredentials: true,
 		Debug:            true, // Enable for debugging CORS issues
 	})
@@ -74,20 +269,56 @@ func main() {
 			Addr:    ":" + port,
 			Handler: handler,
 		}
+		server.RegisterOnShutdown(h.CloseSubscriptions) // Shutdown would wait for the event streams
+		server.RegisterOnShutdown(srv.CloseStreams)
 
-		go func() {
-			log.Printf("Signaling server started, PORT: %v\n", server.Addr)
+		// local always serves cert.pem/key.pem, prod is plain http unless TLS_MODE is "file" or "acme"
+		var tlsMode string
+		switch utils.GetEnv("ENV") {
+		case "local":
+			tlsMode = "file"
+		case "prod":
+			tlsMode = utils.GetEnv("TLS_MODE")
+		default:
+			log.Fatalf("FATAL: 'ENV' environment variable not set or invalid. Must be 'local' or 'prod'.")
+		}
 
+		var tlsSetup *srv.TLSSetup
+		if tlsMode != "" {
 			var err error
-			switch utils.GetEnv("ENV") {
-			case "local":
-				err = server.ListenAndServeTLS("cert.pem", "key.pem")
+			tlsSetup, err = srv.NewTLSSetup(tlsMode)
+			if err != nil {
+				log.Fatalf("FATAL: TLS setup failed: %s", err)
+			}
+			server.TLSConfig = tlsSetup.Config
+		}
+
+		// HTTP-01 challenges need plain http, TLS-ALPN-01 works on the main port without it
+		if tlsSetup != nil && tlsSetup.ACME != nil {
+			if httpPort := utils.GetEnv("ACME_HTTP_PORT"); httpPort != "" {
+				go func() {
+					log.Printf("ACME HTTP-01 challenge listener started, PORT: %v\n", httpPort)
+					if err := http.ListenAndServe(":"+httpPort, tlsSetup.ACME.HTTPHandler(nil)); err != nil {
+						log.Printf("ACME HTTP-01 challenge listener stopped: %s\n", err)
+					}
+				}()
+			}
+		}
 
-			case "prod":
-				err = server.ListenAndServe()
+		if ln == nil {
+			ln, err = net.Listen("tcp", server.Addr)
+			if err != nil {
+				log.Fatalf("Server failed to start: %s\n", err)
+			}
+		}
+		go func() {
+			log.Printf("Signaling server started, PORT: %v, TLS: %q\n", server.Addr, tlsMode)
 
-			default:
-				log.Fatalf("FATAL: 'ENV' environment variable not set or invalid. Must be 'local' or 'prod'.")
+			var err error
+			if tlsSetup != nil {
+				err = server.ServeTLS(ln, "", "") // certs come from server.TLSConfig
+			} else {
+				err = server.Serve(ln)
 			}
 
 			if err != nil && err != http.ErrServerClosed {
@@ -95,16 +326,82 @@ func main() {
 			}
 		}()
 
+		// SIGHUP re-reads certificates, cluster members and API keys from disk, open connections are not touched
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := router.Reload(); err != nil {
+					log.Printf("Cluster members reload failed, keeping old ones: %s\n", err)
+				}
+				if err := keys.Reload(); err != nil {
+					log.Printf("API keys reload failed, keeping old ones: %s\n", err)
+				}
+				if tlsSetup == nil || tlsSetup.Reloader == nil {
+					log.Println("SIGHUP received, no file based certificate to reload")
+					continue
+				}
+				if err := tlsSetup.Reloader.Reload(); err != nil {
+					log.Printf("Certificate reload failed, keeping old one: %s\n", err)
+				}
+			}
+		}()
+
+		// the next process connects to HANDOFF_SOCKET and this one steps down
+		handedOff := make(chan struct{})
+		if handoffPath != "" {
+			hl, err := handoff.Listen(handoffPath)
+			if err != nil {
+				log.Fatalf("FATAL: handoff socket: %s", err)
+			}
+			go func() {
+				err := hl.Handover(func() (handoff.Transfer, error) {
+					return handOver(h, server, ln, func() {
+						if stunServer != nil {
+							stunServer.Close()
+						}
+						if turnServer != nil {
+							turnServer.Close()
+						}
+					})
+				})
+				if err != nil {
+					log.Fatalf("FATAL: handoff failed: %s", err) // not serving anymore either way
+				}
+				close(handedOff)
+			}()
+		}
+
 		quit := make(chan os.Signal, 1)
-		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
-		<-quit
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		select {
+		case <-handedOff:
+			log.Println("Handed over to the new process, exiting")
+			return
+		case <-quit:
+		}
 		log.Println("Shutting down server...")
 
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
+		if stunServer != nil {
+			stunServer.Close()
+		}
+		if turnServer != nil {
+			turnServer.Close()
+		}
+
 		if err := server.Shutdown(ctx); err != nil {
 			log.Fatal("Server forced to shutdown:", err)
 		}
+
+		// WebSockets are still open, their clients get the reconnect grace after the restart
+		if snapshotPath != "" {
+			close(stopSnapshots)
+			if err := h.SaveSnapshot(snapshotPath); err != nil {
+				log.Printf("Snapshot on shutdown failed: %s\n", err)
+			}
+		}
 	}
 }
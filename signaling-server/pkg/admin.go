@@ -0,0 +1,197 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+
+	"signaling-server-webrtc/pkg/bus"
+	"signaling-server-webrtc/pkg/store"
+	"signaling-server-webrtc/pkg/types"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+Operator actions for the admin API. They run in the Run goroutine like everything else
+that changes rooms, and reach clients on other nodes over the bus. Clients are told
+why before their socket closes:
+
+	{"type":"kicked","message":"<reason>"}
+	{"type":"room-closed","message":"<reason>"}
+	{"type":"announcement","message":"<text>"}
+*/
+
+var ErrClientNotFound = errors.New("client not found in room")
+
+// call runs fn in the Run goroutine and waits for it
+func (h *Hub) call(fn func()) {
+	done := make(chan struct{})
+	h.calls <- func() {
+		fn()
+		close(done)
+	}
+	<-done
+}
+
+func serverNotice(kind, message string) []byte {
+	data, _ := json.Marshal(struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}{kind, message})
+	return data
+}
+
+// AdminRooms lists every room of the store with the state of its clients
+func (h *Hub) AdminRooms() ([]types.AdminRoom, error) {
+	rooms, err := h.store.List()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]types.AdminRoom, 0, len(rooms))
+	h.call(func() {
+		for _, room := range rooms {
+			out = append(out, h.adminRoom(room))
+		}
+	})
+	return out, nil
+}
+
+func (h *Hub) AdminRoom(roomId string) (types.AdminRoom, error) {
+	room, err := h.store.Lookup(roomId)
+	if err != nil {
+		return types.AdminRoom{}, err
+	}
+	var out types.AdminRoom
+	h.call(func() { out = h.adminRoom(room) })
+	return out, nil
+}
+
+// adminRoom describes a stored room, this node adds what only it knows (grace, held messages). Must run in the Run goroutine.
+func (h *Hub) adminRoom(room store.Room) types.AdminRoom {
+	out := types.AdminRoom{
+		RoomID:    room.ID,
+		Options:   room.Options,
+		Presenter: room.Options.Presenter,
+		Private:   room.Options.Private,
+		Node:      room.Node,
+		CreatedAt: room.CreatedAt,
+		Clients:   []types.AdminClient{},
+	}
+	if w := h.waiting[room.ID]; w != nil {
+		since := w.since
+		out.WaitingSince = &since
+	}
+
+	var offerer string
+	if room.Options.Presenter == "" && !h.usesSFU(room.ID) && len(room.Clients) == 2 {
+		offerer, _ = meshRoles(room)
+	}
+	for _, clientId := range room.Clients {
+		c := types.AdminClient{ClientID: clientId, State: types.ClientReserved}
+		if node, ok := room.Connected[clientId]; ok {
+			c.State, c.Node = types.ClientConnected, node
+		} else if h.timers.Pending(graceTimerKey(room.ID, clientId)) {
+			c.State = types.ClientReconnecting
+		}
+		switch {
+		case room.Options.Presenter == clientId:
+			c.Role = "presenter"
+		case room.Options.Presenter != "":
+			c.Role = "viewer"
+		case offerer == clientId:
+			c.Role = "offerer"
+		case offerer != "":
+			c.Role = "answerer"
+		}
+		if q := h.pending[room.ID][clientId]; q != nil {
+			c.Pending = len(q.items)
+		}
+		out.Clients = append(out.Clients, c)
+	}
+	return out
+}
+
+// CloseRoom tells every client why, then disconnects them all and deletes the room
+func (h *Hub) CloseRoom(roomId, reason string) error {
+	room, err := h.store.Lookup(roomId)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		reason = "closed by an operator"
+	}
+	h.call(func() {
+		h.notifyRoom(room, "", serverNotice("room-closed", reason))
+		h.closeRoom(roomId)
+	})
+	return nil
+}
+
+// KickClient tells the client why, disconnects it and releases its slot, so it cannot reconnect
+func (h *Hub) KickClient(roomId, clientId, reason string) error {
+	room, err := h.store.Lookup(roomId)
+	if err != nil {
+		return err
+	}
+	if !room.Has(clientId) {
+		return ErrClientNotFound
+	}
+	if reason == "" {
+		reason = "removed by an operator"
+	}
+	h.call(func() { h.kick(room, clientId, serverNotice("kicked", reason)) })
+	return nil
+}
+
+// kick runs on the node holding the client's socket, or releases a slot nobody is connected to. Must run in the Run goroutine.
+func (h *Hub) kick(room store.Room, clientId string, notice []byte) {
+	if c := h.GetClientFromRoom(room.ID, clientId); c != nil {
+		h.sendDirect(c, notice) // WritePump writes it before it sees Send closed
+		if h.usesSFU(room.ID) {
+			h.leaveSFU(room.ID, clientId)
+		}
+		h.broadcastLeft(c)
+		left := h.removeClient(c, false)
+		h.updateWaiting(room.ID, left.Connected)
+		utils.LogRoom(room.ID, clientId, "👢 kicked")
+		return
+	}
+	if node, ok := room.Connected[clientId]; ok && node != h.node {
+		h.publish(bus.Message{Kind: bus.KindKick, RoomID: room.ID, To: clientId, Data: notice})
+		return
+	}
+
+	// reserved or in its reconnect grace, nobody to tell
+	if h.usesSFU(room.ID) {
+		h.leaveSFU(room.ID, clientId) // a WHIP/WHEP session has no socket
+	}
+	h.timers.Cancel(graceTimerKey(room.ID, clientId))
+	h.deleteSlot(room.ID, clientId)
+	utils.LogRoom(room.ID, clientId, "👢 slot released by an operator")
+}
+
+// Announce sends a system message to every connected client of the room, or of every room when roomId is empty.
+// It returns the number of rooms it went to.
+func (h *Hub) Announce(roomId, message string) (int, error) {
+	var rooms []store.Room
+	if roomId != "" {
+		room, err := h.store.Lookup(roomId)
+		if err != nil {
+			return 0, err
+		}
+		rooms = []store.Room{room}
+	} else {
+		var err error
+		if rooms, err = h.store.List(); err != nil {
+			return 0, err
+		}
+	}
+
+	notice := serverNotice("announcement", message)
+	h.call(func() {
+		for _, room := range rooms {
+			h.notifyRoom(room, "", notice)
+		}
+	})
+	utils.LogRoom(roomId, "Nil", "📢 announcement sent to %d rooms", len(rooms))
+	return len(rooms), nil
+}
@@ -0,0 +1,77 @@
+package affinity
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// points per member on the ring, enough for an even spread over a handful of nodes
+const virtualNodes = 128
+
+// Member is one instance of the cluster: its NODE_ID and the base URL clients can reach it on
+type Member struct {
+	ID  string
+	URL string
+}
+
+// Ring maps room ids to members by consistent hashing, adding or removing a member only moves its share
+type Ring struct {
+	members map[string]Member
+	points  []uint64
+	owners  map[uint64]string
+}
+
+func NewRing(members []Member) *Ring {
+	r := &Ring{members: make(map[string]Member), owners: make(map[uint64]string)}
+	for _, m := range members {
+		r.members[m.ID] = m
+		for i := 0; i < virtualNodes; i++ {
+			p := hash(m.ID + "#" + strconv.Itoa(i))
+			r.points = append(r.points, p)
+			r.owners[p] = m.ID
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the member owning the room, ok is false on an empty ring
+func (r *Ring) Owner(roomId string) (m Member, ok bool) {
+	if len(r.points) == 0 {
+		return Member{}, false
+	}
+	h := hash(roomId)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.members[r.owners[r.points[i]]], true
+}
+
+func (r *Ring) Member(id string) (Member, bool) {
+	m, ok := r.members[id]
+	return m, ok
+}
+
+func (r *Ring) Members() []Member {
+	members := make([]Member, 0, len(r.members))
+	for _, m := range r.members {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// hash is FNV-1a with a final mix, keys like "a#1" and "a#2" only differ in their last byte
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
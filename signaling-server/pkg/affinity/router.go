@@ -0,0 +1,317 @@
+/*
+Package affinity pins every room to one node of the cluster, so a room keeps the
+single process Hub semantics (and its SFU) while the cluster scales out. The owner of
+a room is the node that created it as long as that node is a member, otherwise the
+consistent hashing ring decides; requests reaching another node are redirected (307)
+or proxied to the owner.
+
+	CLUSTER_MEMBERS="a=https://a.example.com,b=https://b.example.com"  NODE_ID=a
+
+Members change with CLUSTER_MEMBERS_FILE and SIGHUP. Existing rooms drain on the node
+holding them: with a shared ROOM_STORE every node finds the room's creator, otherwise a
+node that loaded the old member list still finds it through the previous ring. New rooms
+only get ids the new ring maps to the creating node.
+*/
+package affinity
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"signaling-server-webrtc/pkg/store"
+	"signaling-server-webrtc/utils"
+)
+
+const (
+	RoutingRedirect = "redirect"
+	RoutingProxy    = "proxy" // for browsers, their WebSocket does not follow redirects
+
+	// set on forwarded requests, the target serves them without routing again
+	nodeParam = "node"
+)
+
+type Router struct {
+	self        string
+	routing     string
+	membersFile string
+	lookup      func(roomId string) (store.Room, error)
+
+	mu       sync.RWMutex
+	ring     *Ring
+	previous *Ring // before the last membership change, its rooms drain on their old nodes
+	proxies  map[string]*httputil.ReverseProxy
+}
+
+// FromEnv returns nil (no routing, every request is local) when no members are configured
+func FromEnv(self string, lookup func(roomId string) (store.Room, error)) (*Router, error) {
+	r := &Router{
+		self:        self,
+		routing:     utils.GetEnv("CLUSTER_ROUTING"),
+		membersFile: utils.GetEnv("CLUSTER_MEMBERS_FILE"),
+		lookup:      lookup,
+		proxies:     make(map[string]*httputil.ReverseProxy),
+	}
+	switch r.routing {
+	case "":
+		r.routing = RoutingRedirect
+	case RoutingRedirect, RoutingProxy:
+	default:
+		return nil, fmt.Errorf("unknown CLUSTER_ROUTING %q", r.routing)
+	}
+
+	var members []Member
+	var err error
+	if r.membersFile != "" {
+		members, err = readMembers(r.membersFile)
+	} else if env := utils.GetEnv("CLUSTER_MEMBERS"); env != "" {
+		members, err = ParseMembers(env)
+	} else {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.SetMembers(members)
+	return r, nil
+}
+
+// ParseMembers reads "id=url" pairs separated by commas or new lines
+func ParseMembers(s string) ([]Member, error) {
+	var members []Member
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+		id, raw, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("cluster member %q is not id=url", field)
+		}
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("cluster member %s has an invalid url %q", id, raw)
+		}
+		members = append(members, Member{ID: strings.TrimSpace(id), URL: strings.TrimRight(u.String(), "/")})
+	}
+	return members, nil
+}
+
+func readMembers(path string) ([]Member, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var b strings.Builder
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		b.WriteString(sc.Text() + "\n")
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return ParseMembers(b.String())
+}
+
+// Reload re-reads CLUSTER_MEMBERS_FILE, called on SIGHUP
+func (r *Router) Reload() error {
+	if r == nil || r.membersFile == "" {
+		return nil
+	}
+	members, err := readMembers(r.membersFile)
+	if err != nil {
+		return err
+	}
+	r.SetMembers(members)
+	return nil
+}
+
+func (r *Router) SetMembers(members []Member) {
+	ring := NewRing(members)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ring != nil && !sameMembers(r.ring, ring) {
+		r.previous = r.ring
+	}
+	r.ring = ring
+	r.proxies = make(map[string]*httputil.ReverseProxy)
+
+	var ids []string
+	for _, m := range ring.Members() {
+		ids = append(ids, m.ID)
+	}
+	log.Printf("Cluster members: %s, this node: %s, routing: %s\n", strings.Join(ids, ","), r.self, r.routing)
+	if _, ok := ring.Member(r.self); !ok {
+		log.Printf("Node %s is not a cluster member: it only serves its existing rooms\n", r.self)
+	}
+}
+
+func sameMembers(a, b *Ring) bool {
+	am, bm := a.Members(), b.Members()
+	if len(am) != len(bm) {
+		return false
+	}
+	for i := range am {
+		if am[i] != bm[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Owns tells if a new room with this id would belong to this node, hub.CreateRoom only uses such ids
+func (r *Router) Owns(roomId string) bool {
+	if r == nil {
+		return true
+	}
+	r.mu.RLock()
+	ring := r.ring
+	r.mu.RUnlock()
+
+	if _, member := ring.Member(r.self); !member {
+		return true // draining, the router sends room creations elsewhere
+	}
+	m, _ := ring.Owner(roomId)
+	return m.ID == r.self
+}
+
+// owner returns the member to forward to, local is true when this node serves the room
+func (r *Router) owner(roomId string) (m Member, local bool) {
+	r.mu.RLock()
+	ring, previous := r.ring, r.previous
+	r.mu.RUnlock()
+
+	if room, err := r.lookup(roomId); err == nil {
+		if room.Node == "" || room.Node == r.self {
+			return Member{}, true
+		}
+		if m, ok := ring.Member(room.Node); ok {
+			return m, false
+		}
+		// its node left the cluster, with a shared store the ring owner takes the room over
+	}
+
+	m, ok := ring.Owner(roomId)
+	if ok && m.ID != r.self {
+		return m, false
+	}
+	if previous != nil {
+		// maybe created before the last membership change, still on its old node
+		if p, ok := previous.Owner(roomId); ok && p.ID != r.self {
+			if pm, ok := ring.Member(p.ID); ok {
+				return pm, false
+			}
+		}
+	}
+	return Member{}, true
+}
+
+// Room routes requests about an existing room, roomId picks it out of the request
+func (r *Router) Room(roomId func(*http.Request) string, next http.Handler) http.Handler {
+	if r == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := roomId(req)
+		if id == "" || req.URL.Query().Get(nodeParam) == r.self {
+			next.ServeHTTP(w, req)
+			return
+		}
+		m, local := r.owner(id)
+		if local {
+			next.ServeHTTP(w, req)
+			return
+		}
+		r.forward(w, req, m)
+	})
+}
+
+// NewRoom routes room creation: members create locally, a draining node hands it to a member
+func (r *Router) NewRoom(next http.Handler) http.Handler {
+	if r == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.RLock()
+		ring := r.ring
+		r.mu.RUnlock()
+
+		_, member := ring.Member(r.self)
+		if member || req.URL.Query().Get(nodeParam) == r.self {
+			next.ServeHTTP(w, req)
+			return
+		}
+		m, ok := ring.Owner(utils.GenerateShortID())
+		if !ok {
+			utils.WriteError(w, http.StatusServiceUnavailable, "no cluster member to create the room")
+			return
+		}
+		r.forward(w, req, m)
+	})
+}
+
+func (r *Router) forward(w http.ResponseWriter, req *http.Request, m Member) {
+	if r.routing == RoutingProxy {
+		r.proxy(m).ServeHTTP(w, req)
+		return
+	}
+
+	target, _ := url.Parse(m.URL)
+	target.Path = strings.TrimRight(target.Path, "/") + req.URL.Path
+	q := req.URL.Query()
+	q.Set(nodeParam, m.ID)
+	target.RawQuery = q.Encode()
+	http.Redirect(w, req, target.String(), http.StatusTemporaryRedirect) // 307 keeps method and body
+}
+
+func (r *Router) proxy(m Member) *httputil.ReverseProxy {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p := r.proxies[m.ID]; p != nil {
+		return p
+	}
+	target, _ := url.Parse(m.URL)
+	p := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			q := pr.Out.URL.Query()
+			q.Set(nodeParam, m.ID)
+			pr.Out.URL.RawQuery = q.Encode()
+		},
+		// the CORS headers of this node are already set, the owner's would be duplicates
+		ModifyResponse: func(res *http.Response) error {
+			for k := range res.Header {
+				if strings.HasPrefix(k, "Access-Control-") {
+					res.Header.Del(k)
+				}
+			}
+			return nil
+		},
+	}
+	r.proxies[m.ID] = p
+	return p
+}
+
+// QueryRoomID takes the room from ?roomId=
+func QueryRoomID(req *http.Request) string {
+	return req.URL.Query().Get("roomId")
+}
+
+// PathRoomID takes the room from the {roomId} route variable
+func PathRoomID(req *http.Request) string {
+	return mux.Vars(req)["roomId"]
+}
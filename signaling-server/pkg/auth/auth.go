@@ -0,0 +1,197 @@
+/*
+Package auth gives API keys a role and lets router middleware check it. Callers without
+a key are "public": they create and join rooms and see their own room. Operators also
+see every room (stats, admin reads), admins change them (close, kick, announce).
+
+	API_KEYS="operator=k3y-for-grafana,admin=k3y-for-oncall"
+
+A key goes in "Authorization: Bearer <key>" or "X-API-Key: <key>". API_KEYS_FILE holds
+one role=key per line and is re-read on SIGHUP, ADMIN_TOKEN is one more admin key.
+*/
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"signaling-server-webrtc/utils"
+)
+
+type Role int
+
+const (
+	Public Role = iota // no key
+	Operator
+	Admin
+)
+
+func (r Role) String() string {
+	switch r {
+	case Operator:
+		return "operator"
+	case Admin:
+		return "admin"
+	}
+	return "public"
+}
+
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "operator":
+		return Operator, nil
+	case "admin":
+		return Admin, nil
+	}
+	return Public, fmt.Errorf("unknown role %q (operator or admin)", s)
+}
+
+type Keys struct {
+	file       string
+	adminToken string
+
+	mu   sync.RWMutex
+	keys map[string]Role
+}
+
+func FromEnv() (*Keys, error) {
+	k := &Keys{
+		file:       utils.GetEnv("API_KEYS_FILE"),
+		adminToken: utils.GetEnv("ADMIN_TOKEN"),
+	}
+	if k.file != "" {
+		return k, k.Reload()
+	}
+	keys, err := ParseKeys(utils.GetEnv("API_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+	k.set(keys)
+	return k, nil
+}
+
+// ParseKeys reads "role=key" pairs separated by commas or new lines
+func ParseKeys(s string) (map[string]Role, error) {
+	keys := make(map[string]Role)
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+		name, key, ok := strings.Cut(field, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("API key %q: want role=key", field)
+		}
+		role, err := ParseRole(name)
+		if err != nil {
+			return nil, err
+		}
+		keys[strings.TrimSpace(key)] = role
+	}
+	return keys, nil
+}
+
+// Reload re-reads API_KEYS_FILE, the old keys stay on error
+func (k *Keys) Reload() error {
+	if k.file == "" {
+		return nil
+	}
+	f, err := os.Open(k.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	keys, err := ParseKeys(strings.Join(lines, "\n"))
+	if err != nil {
+		return err
+	}
+	k.set(keys)
+	return nil
+}
+
+func (k *Keys) set(keys map[string]Role) {
+	if k.adminToken != "" {
+		keys[k.adminToken] = Admin
+	}
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+}
+
+// Len is the number of configured keys, without any only participants see stats
+func (k *Keys) Len() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.keys)
+}
+
+// RoleOf is the role of the request's key. known is false when a key was given but matches none.
+func (k *Keys) RoleOf(r *http.Request) (role Role, known bool) {
+	given := requestKey(r)
+	if given == "" {
+		return Public, true
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	role, known = Public, false
+	for key, keyRole := range k.keys { // compared with every key, a match does not finish early
+		if subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1 {
+			role, known = keyRole, true
+		}
+	}
+	return role, known
+}
+
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return key
+}
+
+// Require lets a request through when its key has at least role
+func (k *Keys) Require(role Role) mux.MiddlewareFunc {
+	return k.RequireOr(role, nil)
+}
+
+// RequireOr also lets through requests allow accepts without the role, e.g. the participants of a room
+func (k *Keys) RequireOr(role Role, allow func(r *http.Request) bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			have, known := k.RoleOf(r)
+			switch {
+			case !known:
+				w.Header().Set("WWW-Authenticate", `Bearer realm="signaling-server"`)
+				utils.WriteError(w, http.StatusUnauthorized, "unknown API key")
+				return
+			case have >= role:
+			case allow != nil && allow(r):
+			case have == Public:
+				w.Header().Set("WWW-Authenticate", `Bearer realm="signaling-server"`)
+				utils.WriteError(w, http.StatusUnauthorized, role.String()+" API key required")
+				return
+			default:
+				utils.WriteError(w, http.StatusForbidden, role.String()+" role required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Package bot runs headless WebRTC peers inside the server. A bot takes a slot in a room
+like any client, is registered with the Hub as a *pkg.Client (without WebSocket),
+negotiates with the other peer through the hub and hands the "chat" data channel to a
+Handler:
+
+	POST /api/bots/spawn?roomId=abc&bot=echo
+
+Handlers are registered by name, "echo" is built in.
+*/
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Handler is the bot's logic, called from pion's goroutines
+type Handler interface {
+	OnOpen(p *Peer)                                   // data channel is open, p.Send works
+	OnMessage(p *Peer, msg webrtc.DataChannelMessage) // one data channel message from the peer
+	OnClose(p *Peer)                                  // the bot left the room
+}
+
+// TrackHandler is implemented by bots that want the media the peer sends
+type TrackHandler interface {
+	OnTrack(p *Peer, remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
+}
+
+// Negotiator is implemented by bots that add their own tracks: OnOffer runs after the peer's
+// offer is set and before the bot answers
+type Negotiator interface {
+	OnOffer(p *Peer) error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]func() Handler{
+		"echo":     func() Handler { return Echo{} },
+		"loopback": func() Handler { return NewLoopback() },
+	}
+)
+
+// Register makes a bot available to Spawn under name, a new Handler is made per spawned peer
+func Register(name string, factory func() Handler) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// Names lists the registered bots
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func Known(name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	_, ok := registry[name]
+	return ok
+}
+
+func newHandler(name string) (Handler, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	factory, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown bot %q", name)
+	}
+	return factory(), nil
+}
+
+// Echo sends every data channel message back
+type Echo struct{}
+
+func (Echo) OnOpen(p *Peer) {
+	p.Send("echo bot ready, everything you send comes back")
+}
+
+func (Echo) OnMessage(p *Peer, msg webrtc.DataChannelMessage) {
+	if msg.IsString {
+		p.Send(string(msg.Data))
+	} else {
+		p.SendBinary(msg.Data)
+	}
+}
+
+func (Echo) OnClose(p *Peer) {}
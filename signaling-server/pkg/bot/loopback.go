@@ -0,0 +1,218 @@
+package bot
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+
+	"signaling-server-webrtc/utils"
+)
+
+const (
+	reportInterval   = 2 * time.Second
+	loopbackKeyframe = 3 * time.Second
+)
+
+/*
+Loopback is the self-test peer: it echoes the data channel, sends every received
+track back to the peer and reports how the connection is doing over the signaling
+socket, every reportInterval while connected:
+
+	{"type":"selftest-report","data":{
+		"state":"connected",
+		"selectedPair":{"local":{"type":"host","protocol":"udp","address":"10.0.0.5","port":50000},"remote":{...}},
+		"rttMs":12.5,
+		"echoes":3,
+		"packets":{"audio":150,"video":420}
+	}}
+*/
+type Loopback struct {
+	mu      sync.Mutex
+	tracks  map[webrtc.RTPCodecType]*webrtc.TrackLocalStaticRTP // sent back to the peer
+	echoes  int
+	packets map[string]int
+	stop    chan struct{}
+	start   sync.Once
+	closed  sync.Once
+}
+
+type CandidateInfo struct {
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     uint16 `json:"port"`
+}
+
+type CandidatePair struct {
+	Local  CandidateInfo `json:"local"`
+	Remote CandidateInfo `json:"remote"`
+}
+
+type SelfTestReport struct {
+	State        string         `json:"state"`
+	SelectedPair *CandidatePair `json:"selectedPair,omitempty"`
+	RTTMs        float64        `json:"rttMs"` // ICE (STUN) round trip of the selected pair
+	Echoes       int            `json:"echoes"`
+	Packets      map[string]int `json:"packets"`
+}
+
+func NewLoopback() *Loopback {
+	return &Loopback{
+		tracks:  make(map[webrtc.RTPCodecType]*webrtc.TrackLocalStaticRTP),
+		packets: make(map[string]int),
+		stop:    make(chan struct{}),
+	}
+}
+
+func (l *Loopback) OnOpen(p *Peer) {
+	l.start.Do(func() { go l.reportLoop(p) })
+}
+
+func (l *Loopback) OnMessage(p *Peer, msg webrtc.DataChannelMessage) {
+	l.mu.Lock()
+	l.echoes++
+	l.mu.Unlock()
+
+	if msg.IsString {
+		p.Send(string(msg.Data))
+	} else {
+		p.SendBinary(msg.Data)
+	}
+}
+
+func (l *Loopback) OnClose(p *Peer) {
+	l.start.Do(func() {}) // no report loop after close
+	l.closed.Do(func() { close(l.stop) })
+}
+
+// OnOffer adds one outgoing track per kind the peer sends, so the answer is already sendrecv
+func (l *Loopback) OnOffer(p *Peer) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, tr := range p.PeerConnection().GetTransceivers() {
+		kind := tr.Kind()
+		if l.tracks[kind] != nil || tr.Receiver() == nil {
+			continue
+		}
+		codecs := tr.Receiver().GetParameters().Codecs
+		if len(codecs) == 0 {
+			continue
+		}
+		local, err := webrtc.NewTrackLocalStaticRTP(codecs[0].RTPCodecCapability, "loopback-"+kind.String(), "loopback")
+		if err != nil {
+			return err
+		}
+		sender, err := p.PeerConnection().AddTrack(local)
+		if err != nil {
+			return err
+		}
+		go drain(sender)
+		l.tracks[kind] = local
+	}
+	return nil
+}
+
+// OnTrack writes what the peer sends into the track going back to it
+func (l *Loopback) OnTrack(p *Peer, remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+	kind := remote.Kind()
+	l.mu.Lock()
+	local := l.tracks[kind]
+	l.mu.Unlock()
+	if local == nil {
+		return
+	}
+	if !strings.EqualFold(local.Codec().MimeType, remote.Codec().MimeType) {
+		utils.LogRoom(p.RoomID, p.ClientID, "🤖 loopback: peer sends %s, looping back as %s",
+			remote.Codec().MimeType, local.Codec().MimeType)
+	}
+
+	if kind == webrtc.RTPCodecTypeVideo {
+		go l.requestKeyframes(p.PeerConnection(), remote.SSRC())
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := remote.Read(buf)
+		if err != nil {
+			return
+		}
+		l.mu.Lock()
+		l.packets[kind.String()]++
+		l.mu.Unlock()
+		if _, err := local.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+	}
+}
+
+// the peer's decoder needs keyframes to show the looped video
+func (l *Loopback) requestKeyframes(pc *webrtc.PeerConnection, ssrc webrtc.SSRC) {
+	ticker := time.NewTicker(loopbackKeyframe)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)}}) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (l *Loopback) reportLoop(p *Peer) {
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	for {
+		p.Signal("selftest-report", l.report(p.PeerConnection()))
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *Loopback) report(pc *webrtc.PeerConnection) SelfTestReport {
+	l.mu.Lock()
+	r := SelfTestReport{State: pc.ConnectionState().String(), Echoes: l.echoes, Packets: make(map[string]int)}
+	for kind, n := range l.packets {
+		r.Packets[kind] = n
+	}
+	l.mu.Unlock()
+
+	if sctp := pc.SCTP(); sctp != nil {
+		pair, err := sctp.Transport().ICETransport().GetSelectedCandidatePair()
+		if err == nil && pair != nil {
+			r.SelectedPair = &CandidatePair{Local: candidateInfo(pair.Local), Remote: candidateInfo(pair.Remote)}
+		}
+	}
+	for _, s := range pc.GetStats() {
+		if pairStats, ok := s.(webrtc.ICECandidatePairStats); ok && pairStats.Nominated {
+			r.RTTMs = pairStats.CurrentRoundTripTime * 1000
+		}
+	}
+	return r
+}
+
+func candidateInfo(c *webrtc.ICECandidate) CandidateInfo {
+	return CandidateInfo{Type: c.Typ.String(), Protocol: c.Protocol.String(), Address: c.Address, Port: c.Port}
+}
+
+func drain(sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v4"
+
+	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/signal"
+	"signaling-server-webrtc/utils"
+)
+
+var (
+	ErrNotOpen     = errors.New("data channel is not open")
+	ErrRateLimited = errors.New("too many bots started, try again later")
+	ErrTooManyBots = errors.New("too many bots running")
+)
+
+/*
+Spawner starts bots in the rooms of one hub. Every bot holds a PeerConnection, so how many
+may run (BOTS_MAX, default 20) and how fast they are started (BOTS_RATE per second, default 1,
+BOTS_BURST at once, default 5) is capped for the whole server, self-tests included.
+*/
+type Spawner struct {
+	hub    *pkg.Hub
+	config webrtc.Configuration
+
+	limiter *utils.RateLimiter
+	max     int64
+	running atomic.Int64
+}
+
+func NewSpawner(hub *pkg.Hub, iceServers []webrtc.ICEServer) *Spawner {
+	return &Spawner{
+		hub:     hub,
+		config:  webrtc.Configuration{ICEServers: iceServers},
+		limiter: utils.NewRateLimiter(float64(utils.GetEnvInt("BOTS_RATE", 1)), float64(utils.GetEnvInt("BOTS_BURST", 5))),
+		max:     int64(utils.GetEnvInt("BOTS_MAX", 20)),
+	}
+}
+
+/*
+Peer is one running bot. It reads what the hub sends to its Client like WritePump
+would, and answers through Hub.HandleMessage like ReadPump would, so room policies,
+roles and topologies apply to it as to any other client.
+*/
+type Peer struct {
+	RoomID   string
+	ClientID string
+	Bot      string
+
+	hub     *pkg.Hub
+	spawner *Spawner
+	client  *pkg.Client
+	pc      *webrtc.PeerConnection
+	handler Handler
+
+	mu         sync.Mutex
+	dc         *webrtc.DataChannel
+	candidates []webrtc.ICECandidateInit // arrived before the remote description
+
+	outMu    sync.Mutex
+	outgoing [][]byte // signals for the hub, see write
+	outWake  chan struct{}
+	outDone  bool
+
+	leaveOnce sync.Once
+}
+
+// Spawn starts bot name in a slot already reserved for clientId (srv.JoinRoom).
+// ErrTooManyBots or ErrRateLimited when the server runs or started enough of them.
+func (s *Spawner) Spawn(roomId, clientId, name string) (p *Peer, err error) {
+	if s.running.Add(1) > s.max {
+		s.running.Add(-1)
+		return nil, ErrTooManyBots
+	}
+	defer func() {
+		if err != nil {
+			s.running.Add(-1)
+		}
+	}()
+	if !s.limiter.Allow(1) {
+		return nil, ErrRateLimited
+	}
+
+	handler, err := newHandler(name)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := webrtc.NewPeerConnection(s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	p = &Peer{
+		RoomID:   roomId,
+		ClientID: clientId,
+		Bot:      name,
+		hub:      s.hub,
+		spawner:  s,
+		client:   pkg.NewBotClient(roomId, clientId),
+		pc:       pc,
+		handler:  handler,
+		outWake:  make(chan struct{}, 1),
+	}
+
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c != nil {
+			p.Signal("candidate", c.ToJSON())
+		}
+	})
+	pc.OnDataChannel(p.attach)
+	if th, ok := handler.(TrackHandler); ok {
+		pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) { th.OnTrack(p, remote, receiver) })
+	}
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		utils.LogRoom(roomId, clientId, "🤖 %s bot connection %s", name, state)
+		if state == webrtc.PeerConnectionStateFailed {
+			p.Leave()
+		}
+	})
+
+	s.hub.Register <- p.client
+	go p.run()
+	go p.write()
+	utils.LogRoom(roomId, clientId, "🤖 %s bot joined", name)
+	return p, nil
+}
+
+// Send writes a text message on the data channel
+func (p *Peer) Send(text string) error {
+	dc := p.channel()
+	if dc == nil {
+		return ErrNotOpen
+	}
+	return dc.SendText(text)
+}
+
+func (p *Peer) SendBinary(data []byte) error {
+	dc := p.channel()
+	if dc == nil {
+		return ErrNotOpen
+	}
+	return dc.Send(data)
+}
+
+// Leave unregisters the bot, the hub then closes its Send channel and run cleans up
+func (p *Peer) Leave() {
+	p.leaveOnce.Do(func() {
+		// not inline: the caller may be run itself, while the hub is blocked writing to it
+		go func() { p.hub.Unregister <- p.client }()
+	})
+}
+
+func (p *Peer) channel() *webrtc.DataChannel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.dc == nil || p.dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return nil
+	}
+	return p.dc
+}
+
+func (p *Peer) attach(dc *webrtc.DataChannel) {
+	p.mu.Lock()
+	p.dc = dc
+	p.mu.Unlock()
+
+	dc.OnOpen(func() { p.handler.OnOpen(p) })
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) { p.handler.OnMessage(p, msg) })
+}
+
+// run gets everything the hub sends to the bot until the hub closes the channel
+func (p *Peer) run() {
+	for raw := range p.client.Send {
+		if err := p.handle(raw); err != nil {
+			utils.LogRoom(p.RoomID, p.ClientID, "🤖 %s bot: %s", p.Bot, err)
+		}
+	}
+
+	p.outMu.Lock()
+	p.outDone = true
+	p.outgoing = nil
+	close(p.outWake)
+	p.outMu.Unlock()
+
+	p.pc.Close()
+	p.spawner.running.Add(-1)
+	p.handler.OnClose(p)
+	utils.LogRoom(p.RoomID, p.ClientID, "🤖 %s bot left", p.Bot)
+}
+
+func (p *Peer) handle(raw []byte) error {
+	msg, ok := signal.Parse(raw)
+	if !ok {
+		return nil
+	}
+
+	switch msg.Type {
+	case "role":
+		var role struct {
+			Role string `json:"role"`
+		}
+		json.Unmarshal(msg.Data, &role)
+		if role.Role == "offerer" {
+			return p.offer()
+		}
+
+	case "offer":
+		var offer webrtc.SessionDescription
+		if err := json.Unmarshal(msg.Data, &offer); err != nil {
+			return err
+		}
+		if err := p.setRemote(offer); err != nil {
+			return err
+		}
+		if n, ok := p.handler.(Negotiator); ok {
+			if err := n.OnOffer(p); err != nil {
+				return err
+			}
+		}
+		answer, err := p.pc.CreateAnswer(nil)
+		if err != nil {
+			return err
+		}
+		if err := p.pc.SetLocalDescription(answer); err != nil {
+			return err
+		}
+		p.Signal("answer", answer)
+
+	case "answer":
+		var answer webrtc.SessionDescription
+		if err := json.Unmarshal(msg.Data, &answer); err != nil {
+			return err
+		}
+		return p.setRemote(answer)
+
+	case "candidate":
+		var candidate webrtc.ICECandidateInit
+		if err := json.Unmarshal(msg.Data, &candidate); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.pc.RemoteDescription() == nil {
+			p.candidates = append(p.candidates, candidate)
+			return nil
+		}
+		return p.pc.AddICECandidate(candidate)
+
+	case "timeout":
+		p.Leave() // nobody joined, no reason to keep the room alive
+
+	case "error":
+		log.Printf("[Room:%s] [Client:%s] 🤖 server refused a bot message: %s\n", p.RoomID, p.ClientID, msg.Data)
+	}
+	return nil
+}
+
+// offer opens the data channel on the bot's side, when the room makes it the offerer
+func (p *Peer) offer() error {
+	dc, err := p.pc.CreateDataChannel("chat", nil)
+	if err != nil {
+		return err
+	}
+	p.attach(dc)
+
+	offer, err := p.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err := p.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	p.Signal("offer", offer)
+	return nil
+}
+
+func (p *Peer) setRemote(desc webrtc.SessionDescription) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.pc.SetRemoteDescription(desc); err != nil {
+		return err
+	}
+	for _, c := range p.candidates {
+		p.pc.AddICECandidate(c)
+	}
+	p.candidates = nil
+	return nil
+}
+
+// PeerConnection is the bot's side of the call, for handlers that need more than the data channel
+func (p *Peer) PeerConnection() *webrtc.PeerConnection {
+	return p.pc
+}
+
+// Signal sends a message to the room through the hub exactly like a message read from a WebSocket
+func (p *Peer) Signal(msgType string, data any) {
+	raw, _ := json.Marshal(data)
+	msg, _ := json.Marshal(signal.Message{Type: msgType, Data: raw})
+
+	p.outMu.Lock()
+	if p.outDone {
+		p.outMu.Unlock()
+		return
+	}
+	p.outgoing = append(p.outgoing, msg)
+	p.outMu.Unlock()
+
+	select {
+	case p.outWake <- struct{}{}:
+	default:
+	}
+}
+
+/*
+write hands the bot's signals to the hub in order, like ReadPump. Not from run: HandleMessage
+may wait for Run, while Run waits for run to make room in the bot's Send channel.
+*/
+func (p *Peer) write() {
+	for range p.outWake {
+		for {
+			p.outMu.Lock()
+			msgs := p.outgoing
+			p.outgoing = nil
+			p.outMu.Unlock()
+			if len(msgs) == 0 {
+				break
+			}
+			for _, msg := range msgs {
+				p.hub.HandleMessage(p.client, msg)
+			}
+		}
+	}
+}
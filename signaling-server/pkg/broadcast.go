@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"signaling-server-webrtc/pkg/signal"
+	"signaling-server-webrtc/pkg/store"
+	"signaling-server-webrtc/pkg/types"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+Broadcast rooms have one presenter (the client that created the room) and any number
+of receive-only viewers. Signaling only flows between the presenter and each viewer:
+
+	presenter --{"type":"offer","to":"V1",...}--> hub --{"type":"offer","from":"P",...}--> V1
+	V1 --------{"type":"answer",...}------------> hub --{"type":"answer","from":"V1",...}--> presenter
+
+A presenter message without "to" goes to every viewer. Viewers can only send their own
+negotiation messages, always to the presenter, and their offers/answers must not send
+audio or video. With "broadcast-sfu" viewers negotiate with the server instead and the
+presenter publishes to it once.
+*/
+
+// messages a viewer may send, anything else is refused
+var viewerMessages = map[string]bool{"offer": true, "answer": true, "candidate": true}
+
+// presenter returns the presenter of a broadcast room, "" for every other topology
+func (h *Hub) presenter(roomId string) string {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+
+	if opts := h.Options[roomId]; opts != nil {
+		return opts.Presenter
+	}
+	return ""
+}
+
+func (h *Hub) isViewer(c *Client) bool {
+	presenter := h.presenter(c.RoomID)
+	return presenter != "" && presenter != c.ClientId
+}
+
+/*
+address decides who gets a message of a broadcast room and stamps its sender in "from".
+Runs in the client's ReadPump like inspect. Other rooms get ("", raw, nil): everyone, untouched.
+*/
+func (h *Hub) address(c *Client, raw []byte) (to string, data []byte, err *signal.Error) {
+	presenter := h.presenter(c.RoomID)
+	if presenter == "" {
+		return "", raw, nil
+	}
+
+	msg, ok := signal.Parse(raw)
+	if c.ClientId != presenter {
+		if !ok || !viewerMessages[msg.Type] {
+			return "", nil, signal.NewError("viewer-not-allowed", "viewers can only send offer, answer and candidate")
+		}
+		if err := h.checkViewerDescription(c, msg); err != nil {
+			return "", nil, err
+		}
+		to = presenter
+	} else if ok && msg.To != "" {
+		if !h.IsReserved(c.RoomID, msg.To) {
+			return "", nil, signal.NewError("unknown-recipient", "%s is not in the room", msg.To)
+		}
+		to = msg.To
+	}
+	if !ok {
+		return "", raw, nil // presenter's untyped message, everyone gets it as is
+	}
+
+	// "to" is dropped and "from" set, the other fields are kept as the client sent them
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) != nil {
+		return to, raw, nil
+	}
+	delete(fields, "to")
+	fields["from"], _ = json.Marshal(c.ClientId)
+	data, _ = json.Marshal(fields)
+	return to, data, nil
+}
+
+// checkViewerDescription refuses a viewer offer/answer that would push media to the presenter.
+// The SFU drops what viewers publish by itself, this is for the mesh broadcast.
+func (h *Hub) checkViewerDescription(c *Client, msg signal.Message) *signal.Error {
+	if (msg.Type != "offer" && msg.Type != "answer") || h.usesSFU(c.RoomID) {
+		return nil
+	}
+	desc, err := h.sdpPolicy.ValidateDescription(msg.Type, msg.Data)
+	if err != nil {
+		return err
+	}
+	if i := signal.SendingSection(desc); i >= 0 {
+		utils.LogRoom(c.RoomID, c.ClientId, "🚫 Refused viewer %s sending media", msg.Type)
+		return signal.NewError("viewer-not-allowed", "viewers are receive-only, media section %d sends %s", i, desc.MediaDescriptions[i].MediaName.Media)
+	}
+	return nil
+}
+
+// broadcastJoined hands out the roles and, without SFU, tells the presenter which viewers to offer to.
+// room is the one returned by the store when c connected.
+func (h *Hub) broadcastJoined(c *Client, room store.Room) {
+	presenter := h.presenter(c.RoomID)
+	if c.ClientId == presenter {
+		h.sendDirect(c, []byte(`{"type":"role","data":{"role":"presenter"}}`))
+		h.emit(types.EventRoleAssigned, c.RoomID, c.ClientId, map[string]any{"role": "presenter"})
+	} else {
+		h.sendDirect(c, []byte(fmt.Sprintf(`{"type":"role","data":{"role":"viewer","presenter":%q}}`, presenter)))
+		h.emit(types.EventRoleAssigned, c.RoomID, c.ClientId, map[string]any{"role": "viewer", "presenter": presenter})
+	}
+	if h.usesSFU(c.RoomID) {
+		return // the server offers to the viewers
+	}
+
+	if c.ClientId != presenter {
+		// it gets the list of viewers when it connects, the presenter may be on another node
+		h.deliver(room, presenter, []byte(fmt.Sprintf(`{"type":"viewer-joined","data":{"clientId":%q}}`, c.ClientId)))
+		return
+	}
+	for viewer := range room.Connected {
+		if viewer != presenter {
+			h.sendDirect(c, []byte(fmt.Sprintf(`{"type":"viewer-joined","data":{"clientId":%q}}`, viewer)))
+		}
+	}
+}
+
+// broadcastLeft lets the presenter drop the viewer's connection, or the viewers know the presenter is gone
+func (h *Hub) broadcastLeft(c *Client) {
+	presenter := h.presenter(c.RoomID)
+	if presenter == "" || h.GetClientFromRoom(c.RoomID, c.ClientId) != c {
+		return
+	}
+
+	h.Mu.RLock()
+	room := h.localRoom(c.RoomID)
+	h.Mu.RUnlock()
+	if c.ClientId != presenter {
+		h.deliver(room, presenter, []byte(fmt.Sprintf(`{"type":"viewer-left","data":{"clientId":%q}}`, c.ClientId)))
+		return
+	}
+	h.notifyRoom(room, c.ClientId, []byte(`{"type":"presenter-left"}`))
+}
+
+// broadcastStats adds presenter and connected viewer count, the caller holds Mu
+func (h *Hub) broadcastStats(stats *types.RoomStats, opts *types.RoomOptions, connected map[string]string) {
+	if opts.Presenter == "" {
+		return
+	}
+	viewers := 0
+	for clientId := range connected {
+		if clientId != opts.Presenter {
+			viewers++
+		}
+	}
+	stats.Presenter = opts.Presenter
+	stats.Viewers = &viewers
+}
+
+// sendDirect writes a server message to c without blocking the hub. Must run in the Run goroutine.
+func (h *Hub) sendDirect(c *Client, msg []byte) {
+	select {
+	case c.Send <- msg:
+	default:
+		utils.LogRoom(c.RoomID, c.ClientId, "Cannot send message - channel unavailable")
+	}
+}
@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"signaling-server-webrtc/pkg/bus"
+	"signaling-server-webrtc/pkg/store"
+	"signaling-server-webrtc/pkg/types"
+)
+
+// description builds an offer/answer message with one audio and one video section in the given directions
+func description(msgType string, directions ...string) []byte {
+	sdp := "v=0\r\no=- 1 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"
+	for i, d := range directions {
+		kind, codec := "audio", "111 opus/48000/2"
+		if i == 1 {
+			kind, codec = "video", "96 VP8/90000"
+		}
+		sdp += "m=" + kind + " 9 UDP/TLS/RTP/SAVPF " + strings.Fields(codec)[0] + "\r\nc=IN IP4 0.0.0.0\r\na=rtpmap:" + codec + "\r\n"
+		if d != "" {
+			sdp += "a=" + d + "\r\n"
+		}
+	}
+	data, _ := json.Marshal(map[string]string{"type": msgType, "sdp": sdp})
+	msg, _ := json.Marshal(map[string]any{"type": msgType, "data": json.RawMessage(data)})
+	return msg
+}
+
+func TestBroadcastViewerIsReceiveOnly(t *testing.T) {
+	h := NewHub(store.NewMemory(), bus.NewLocal().Join("n1"))
+	go h.Run()
+
+	opts := types.RoomOptions{Topology: TopologyBroadcast, Presenter: "p"}
+	if err := h.CreateRoom("r1", opts, "p", "v"); err != nil {
+		t.Fatal(err)
+	}
+	p, v := NewBotClient("r1", "p"), NewBotClient("r1", "v")
+	h.Register <- p
+	h.Register <- v
+	receive(t, p, "role")
+	receive(t, v, "role")
+
+	for _, refused := range [][]byte{
+		description("offer", "sendrecv", "recvonly"),
+		description("answer", "recvonly", "sendonly"),
+		description("answer", "", "recvonly"), // no direction means sendrecv
+	} {
+		h.HandleMessage(v, refused)
+		msg := receive(t, v, "error")
+		if !strings.Contains(string(msg.Data), `"code":"viewer-not-allowed"`) {
+			t.Errorf("viewer got %s", msg.Data)
+		}
+	}
+
+	h.HandleMessage(v, description("answer", "recvonly", "inactive"))
+	if msg := receive(t, p, "answer"); msg.From != "v" {
+		t.Errorf("presenter got an answer from %q", msg.From)
+	}
+
+	// the presenter sends, of course
+	h.HandleMessage(p, description("offer", "sendonly", "sendonly"))
+	receive(t, v, "offer")
+}
@@ -0,0 +1,73 @@
+/*
+Package bus carries hub traffic between the instances of the server. Every node
+publishes what it cannot deliver itself (a client whose socket is on another node)
+and what the others need to know (somebody connected, left, a room was closed);
+each node delivers to the sockets it holds and ignores the rest.
+*/
+package bus
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"signaling-server-webrtc/utils"
+)
+
+// message kinds
+const (
+	KindSignal   = "signal"   // Data for To, or for everybody in the room but From
+	KindReserved = "reserved" // From got a slot in the room
+	KindJoined   = "joined"   // From connected on Node
+	KindLeft     = "left"     // From's socket on Node is gone
+	KindClosed   = "closed"   // the room was closed, drop its sockets
+	KindKick     = "kick"     // send Data to To, then disconnect it and release its slot
+	KindPost     = "post"     // Data was POSTed by From on another node, for its SSE stream
+)
+
+var ErrFull = errors.New("bus: publish queue full, message dropped")
+
+type Message struct {
+	Kind   string `json:"kind"`
+	Node   string `json:"node"` // publisher
+	RoomID string `json:"roomId"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+}
+
+type Bus interface {
+	// Node is this instance's id, the room store records it for connected clients
+	Node() string
+	// Publish sends msg to the other nodes without blocking, msg.Node is filled in
+	Publish(msg Message) error
+	// Messages delivers what the other nodes published, never this node's own messages
+	Messages() <-chan Message
+	Close() error
+}
+
+// FromEnv picks the bus from BUS: "local" (default, single instance) or "redis" (REDIS_URL)
+func FromEnv() (Bus, error) {
+	node := utils.GetEnv("NODE_ID")
+	if node == "" {
+		host, _ := os.Hostname()
+		node = host + "-" + utils.GenerateShortID()
+	}
+
+	switch kind := utils.GetEnv("BUS"); kind {
+	case "", "local":
+		return NewLocal().Join(node), nil
+	case "redis":
+		url := utils.GetEnv("REDIS_URL")
+		if url == "" {
+			return nil, errors.New("REDIS_URL must be set for BUS=redis")
+		}
+		prefix := utils.GetEnv("REDIS_KEY_PREFIX")
+		if prefix == "" {
+			prefix = "signal:"
+		}
+		return NewRedis(url, prefix+"bus", node)
+	default:
+		return nil, fmt.Errorf("unknown BUS %q", kind)
+	}
+}
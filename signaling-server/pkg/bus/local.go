@@ -0,0 +1,68 @@
+package bus
+
+import "sync"
+
+const queueSize = 1024
+
+// Local connects hubs of the same process, e.g. several nodes in one test binary. A single
+// joined node is the plain one instance setup: nobody else to publish to.
+type Local struct {
+	mu    sync.RWMutex
+	nodes map[string]*localNode
+}
+
+type localNode struct {
+	local    *Local
+	node     string
+	messages chan Message
+}
+
+func NewLocal() *Local {
+	return &Local{nodes: make(map[string]*localNode)}
+}
+
+// Join adds a node to the in-process bus
+func (l *Local) Join(node string) Bus {
+	n := &localNode{local: l, node: node, messages: make(chan Message, queueSize)}
+
+	l.mu.Lock()
+	l.nodes[node] = n
+	l.mu.Unlock()
+	return n
+}
+
+func (n *localNode) Node() string {
+	return n.node
+}
+
+func (n *localNode) Publish(msg Message) error {
+	msg.Node = n.node
+
+	n.local.mu.RLock()
+	defer n.local.mu.RUnlock()
+
+	var err error
+	for id, other := range n.local.nodes {
+		if id == n.node {
+			continue
+		}
+		select {
+		case other.messages <- msg:
+		default:
+			err = ErrFull // a stuck node must not block the publisher's hub
+		}
+	}
+	return err
+}
+
+func (n *localNode) Messages() <-chan Message {
+	return n.messages
+}
+
+func (n *localNode) Close() error {
+	n.local.mu.Lock()
+	defer n.local.mu.Unlock()
+
+	delete(n.local.nodes, n.node)
+	return nil
+}
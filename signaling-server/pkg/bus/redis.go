@@ -0,0 +1,118 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+Redis is a pub/sub bus on one channel: every node gets every message and the hub drops
+the rooms it holds no socket of. Plenty for a handful of replicas. Publishing goes
+through a queue so the hub never waits for the network, and one publisher goroutine
+keeps the messages of a node in order.
+
+Redis pub/sub delivers at most once. What is published while a node's subscription is
+reconnecting never reaches it, and a full queue (ErrFull) or a failed publish drops the
+message; both are logged, nothing is retried. A lost offer or candidate stalls that call
+until the clients renegotiate or reconnect, so keep Redis close and the nodes healthy.
+*/
+type Redis struct {
+	rdb      *redis.Client
+	pubsub   *redis.PubSub
+	channel  string
+	node     string
+	out      chan Message
+	messages chan Message
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func NewRedis(url, channel, node string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	rdb := redis.NewClient(opts)
+
+	// wait for the subscription, a message published right after startup must not be missed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pubsub := rdb.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		rdb.Close()
+		return nil, err
+	}
+
+	r := &Redis{
+		rdb:      rdb,
+		pubsub:   pubsub,
+		channel:  channel,
+		node:     node,
+		out:      make(chan Message, queueSize),
+		messages: make(chan Message, queueSize),
+		done:     make(chan struct{}),
+	}
+	go r.receive()
+	go r.publish()
+	return r, nil
+}
+
+func (r *Redis) Node() string {
+	return r.node
+}
+
+func (r *Redis) Publish(msg Message) error {
+	msg.Node = r.node
+	select {
+	case r.out <- msg:
+		return nil
+	default:
+		return ErrFull
+	}
+}
+
+func (r *Redis) Messages() <-chan Message {
+	return r.messages
+}
+
+func (r *Redis) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	r.pubsub.Close()
+	return r.rdb.Close()
+}
+
+func (r *Redis) receive() {
+	for m := range r.pubsub.Channel() {
+		var msg Message
+		if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil || msg.Node == r.node {
+			continue
+		}
+		select {
+		case r.messages <- msg:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *Redis) publish() {
+	for {
+		select {
+		case msg := <-r.out:
+			payload, _ := json.Marshal(msg)
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			if err := r.rdb.Publish(ctx, r.channel, payload).Err(); err != nil {
+				log.Printf("bus: publish for room %s failed: %s\n", msg.RoomID, err)
+			}
+			cancel()
+		case <-r.done:
+			return
+		}
+	}
+}
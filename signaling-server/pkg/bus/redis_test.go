@@ -0,0 +1,48 @@
+package bus
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedis(t *testing.T, mr *miniredis.Miniredis, node string) *Redis {
+	t.Helper()
+	r, err := NewRedis("redis://"+mr.Addr(), "test:bus", node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRedisPublish(t *testing.T) {
+	mr := miniredis.RunT(t)
+	n1, n2 := newTestRedis(t, mr, "n1"), newTestRedis(t, mr, "n2")
+
+	for i := range 10 {
+		if err := n1.Publish(Message{Kind: KindSignal, RoomID: "r1", From: "a", Data: []byte(fmt.Sprint(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// in order, stamped with the publisher
+	for i := range 10 {
+		select {
+		case msg := <-n2.Messages():
+			if msg.Node != "n1" || string(msg.Data) != fmt.Sprint(i) {
+				t.Fatalf("message %d: %+v", i, msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message %d not received", i)
+		}
+	}
+
+	// a node never gets its own messages back
+	select {
+	case msg := <-n1.Messages():
+		t.Errorf("n1 got its own message %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -2,56 +2,153 @@ package pkg
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
+
+	"signaling-server-webrtc/pkg/signal"
 )
 
 type Client struct {
-	Connection *websocket.Conn // this is a websocket connection
-	Send       chan []byte     // this is a channel to send messages to the client
-	RoomID     string
+	transport Transport   // WebSocket or SSE stream, nil for server side bots
+	Send      chan []byte // this is a channel to send messages to the client
+	RoomID    string
 	// Hub        hub.Hub
 	ClientId string
+
+	// a handoff stops the pumps but leaves the socket open for the next process, see handoff.go
+	handoff  chan struct{}
+	detached atomic.Bool
+	reading  sync.WaitGroup
+	writing  sync.WaitGroup
+}
+
+// NewClient wraps an upgraded WebSocket, Start runs it once it is registered
+func NewClient(conn *websocket.Conn, roomId, clientId string) *Client {
+	return newClient(wsTransport{conn}, roomId, clientId)
+}
+
+// NewBotClient is a client without transport for server side bots: they read Send and answer through HandleMessage
+func NewBotClient(roomId, clientId string) *Client {
+	return newClient(nil, roomId, clientId)
+}
+
+func newClient(t Transport, roomId, clientId string) *Client {
+	return &Client{
+		transport: t,
+		ClientId:  clientId,
+		RoomID:    roomId,
+		Send:      make(chan []byte, 256),
+		handoff:   make(chan struct{}),
+	}
+}
+
+// Start runs the per-client goroutines
+func (c *Client) Start(hub *Hub) {
+	c.reading.Add(1)
+	c.writing.Add(1)
+	go c.WritePump()
+	go c.ReadPump(hub)
+}
+
+// stopReading ends the ReadPump without closing the socket or leaving the room, the WritePump goes on
+func (c *Client) stopReading() {
+	c.detached.Store(true)
+	c.transport.interrupt() // wakes up Read
+	c.reading.Wait()
+}
+
+// stopWriting ends the WritePump, what is left in Send goes along with the socket
+func (c *Client) stopWriting() {
+	close(c.handoff)
+	c.writing.Wait()
 }
 
 type MessageEnvelope struct {
 	Sender *Client
 	RoomID string
 	Data   []byte
+	Direct bool   // server reply, Data goes back to Sender only
+	To     string // only this client of the room gets it (broadcast rooms), "" = everyone
 }
 
 func (c *Client) ReadPump(hub *Hub) {
 	// this go routine func should run endlessly
+	defer c.reading.Done()
 	defer func() {
+		if c.detached.Load() {
+			return // handed off, the next process reads on
+		}
 		// this defer func will only be called if the code breaks due to error
 		hub.Unregister <- c // sending c to channel Unregister
-		c.Connection.Close()
+		c.transport.Close()
 	}()
 
 	for {
-		_, message, err := c.Connection.ReadMessage()
+		message, err := c.transport.Read()
 		if err != nil {
-			break // Client disconnected -> it will Unregister
+			break // Client disconnected (or handed off) -> it will Unregister
 		}
+		hub.HandleMessage(c, message)
+	}
+}
 
-		hub.Broadcast <- MessageEnvelope{
-			Sender: c,
-			RoomID: c.RoomID,
-			Data:   message,
-		}
+// HandleMessage takes one message from c, whatever carries it (WebSocket, SSE stream, server side bot).
+// Must not be called from the Run goroutine, it ends in h.Broadcast.
+func (h *Hub) HandleMessage(c *Client, message []byte) {
+	// offers/answers are validated here, a refused message goes back to the sender only
+	relayed, msgErr := h.inspect(c, message)
+	if msgErr != nil {
+		refused, _ := signal.Parse(message)
+		c.sendError(h, msgErr, refused.Type)
+		return
+	}
+	if relayed == nil {
+		return // filtered out by the room policy
+	}
+	to, addressed, msgErr := h.address(c, relayed)
+	if msgErr != nil {
+		refused, _ := signal.Parse(message)
+		c.sendError(h, msgErr, refused.Type)
+		return
+	}
+	if h.toSFU(c, relayed) {
+		return // negotiated with the server, nothing to relay
+	}
+
+	h.countRelay(c.RoomID, len(addressed))
+	h.Broadcast <- MessageEnvelope{
+		Sender: c,
+		RoomID: c.RoomID,
+		Data:   addressed,
+		To:     to,
 	}
 }
 
 func (c *Client) WritePump() {
-	defer c.Connection.Close()
+	defer c.writing.Done()
+	defer func() {
+		if !c.detached.Load() {
+			c.transport.Close()
+		}
+	}()
 
 	// here Send is a channel so if it ends then the loop will wait for new value to appear here.
-	// if the channle is closed then only the loop ends.
-	for message := range c.Send {
-		fmt.Println("new message: ", string(message))
-		err := c.Connection.WriteMessage(websocket.TextMessage, message)
-		if err != nil {
-			break // Write failed (disconnected or closed)
+	// if the channle is closed then only the loop ends. A handoff ends it too, what is left in Send goes along.
+	for {
+		select {
+		case <-c.handoff:
+			return
+		case message, ok := <-c.Send:
+			if !ok {
+				return
+			}
+			fmt.Println("new message: ", string(message))
+			err := c.transport.Write(message)
+			if err != nil {
+				return // Write failed (disconnected or closed)
+			}
 		}
 	}
 }
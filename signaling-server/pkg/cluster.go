@@ -0,0 +1,207 @@
+package pkg
+
+import (
+	"sort"
+
+	"signaling-server-webrtc/pkg/bus"
+	"signaling-server-webrtc/pkg/store"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+With several instances the two sockets of a room may sit on different nodes. The
+RoomStore knows which node holds which client (Connect/Disconnect), so role and wait
+decisions see every connected client, and anything for a socket held elsewhere goes
+over the Bus:
+
+	node A: sendToRoom ── local sockets
+	                   └─ bus.Publish(signal) ──> node B: fromBus ── B's sockets
+
+Presence is published too (reserved/joined/left/closed): a node that held messages for a
+client forwards them once it connects elsewhere, and waiting rooms learn that a peer arrived.
+Each node keeps what it heard in Hub.remote, so relaying does not ask the store per message.
+SFU media stays on the node that negotiated it, all clients of an "sfu" room should
+land on the same node.
+*/
+
+func (h *Hub) publish(msg bus.Message) {
+	if err := h.bus.Publish(msg); err != nil {
+		utils.LogRoom(msg.RoomID, msg.From, "bus: %s", err)
+	}
+}
+
+// fromBus handles what another node published. Runs in the Run goroutine.
+func (h *Hub) fromBus(m bus.Message) {
+	switch m.Kind {
+	case bus.KindSignal:
+		if m.To != "" {
+			if c := h.GetClientFromRoom(m.RoomID, m.To); c != nil {
+				h.sendDirect(c, m.Data)
+			}
+			return
+		}
+		for _, c := range h.connectedClients(m.RoomID) {
+			if c.ClientId != m.From {
+				h.sendDirect(c, m.Data)
+			}
+		}
+
+	case bus.KindReserved:
+		h.Mu.Lock()
+		if room := h.Rooms[m.RoomID]; room != nil {
+			if _, ok := room[m.From]; !ok {
+				room[m.From] = nil
+			}
+		}
+		h.Mu.Unlock()
+
+	case bus.KindJoined:
+		h.timers.Cancel(graceTimerKey(m.RoomID, m.From)) // it came back on another node
+		h.movedAway(m.RoomID, m.From, m.Node)
+		h.forwardPending(m.RoomID, m.From)
+		h.refreshWaiting(m.RoomID)
+
+	case bus.KindLeft:
+		h.Mu.Lock()
+		if h.remote[m.RoomID][m.From] == m.Node {
+			delete(h.remote[m.RoomID], m.From)
+		}
+		h.Mu.Unlock()
+		h.refreshWaiting(m.RoomID)
+
+	case bus.KindKick:
+		if h.GetClientFromRoom(m.RoomID, m.To) != nil {
+			h.Mu.RLock()
+			room := h.localRoom(m.RoomID)
+			h.Mu.RUnlock()
+			h.kick(room, m.To, m.Data)
+		}
+
+	case bus.KindPost:
+		if s := h.Stream(m.RoomID, m.From); s != nil && !s.forward(m.Data) {
+			utils.LogRoom(m.RoomID, m.From, "POSTed message from another node dropped, stream queue full")
+		}
+
+	case bus.KindClosed:
+		if h.knowsRoom(m.RoomID) {
+			h.dropRoom(m.RoomID)
+		}
+	}
+}
+
+// deliver sends a server notice to one connected client, wherever its socket is. Must run in the Run goroutine.
+func (h *Hub) deliver(room store.Room, clientId string, data []byte) {
+	if c := h.GetClientFromRoom(room.ID, clientId); c != nil {
+		h.sendDirect(c, data)
+		return
+	}
+	if node, ok := room.Connected[clientId]; ok && node != h.node {
+		h.publish(bus.Message{Kind: bus.KindSignal, RoomID: room.ID, To: clientId, Data: data})
+	}
+}
+
+// notifyRoom sends a server notice to every connected client but except, on every node
+func (h *Hub) notifyRoom(room store.Room, except string, data []byte) {
+	remote := false
+	for clientId, node := range room.Connected {
+		if clientId == except {
+			continue
+		}
+		if c := h.GetClientFromRoom(room.ID, clientId); c != nil {
+			h.sendDirect(c, data)
+		} else if node != h.node {
+			remote = true
+		}
+	}
+	if remote {
+		h.publish(bus.Message{Kind: bus.KindSignal, RoomID: room.ID, From: except, Data: data})
+	}
+}
+
+// movedAway records that the client connected on node, and drops its local socket if it had one
+func (h *Hub) movedAway(roomId, clientId, node string) {
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+
+	room, known := h.Rooms[roomId]
+	if !known {
+		return // nothing of the room here, hydrate fills the cache if a client of it shows up
+	}
+	if c := room[clientId]; c != nil {
+		close(c.Send) // its ReadPump then unregisters a client that is already gone
+		utils.LogRoom(roomId, clientId, "🔀 reconnected on another node, old socket closed")
+	}
+	room[clientId] = nil
+	if h.remote[roomId] == nil {
+		h.remote[roomId] = make(map[string]string)
+	}
+	h.remote[roomId][clientId] = node
+}
+
+// forwardPending hands what this node held for a client to the node it connected on
+func (h *Hub) forwardPending(roomId, clientId string) {
+	q := h.pending[roomId][clientId]
+	if q == nil {
+		return
+	}
+	delete(h.pending[roomId], clientId)
+
+	q.dropExpired()
+	for _, m := range q.items {
+		h.publish(bus.Message{Kind: bus.KindSignal, RoomID: roomId, To: clientId, Data: m.data})
+	}
+}
+
+// refreshWaiting re-evaluates the wait timer after a presence change on another node
+func (h *Hub) refreshWaiting(roomId string) {
+	if len(h.connectedClients(roomId)) == 0 {
+		return // nobody waits here, every node gets every message
+	}
+	h.Mu.RLock()
+	room := h.localRoom(roomId)
+	h.Mu.RUnlock()
+	h.updateWaiting(roomId, room.Connected)
+}
+
+func (h *Hub) knowsRoom(roomId string) bool {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+
+	_, ok := h.Rooms[roomId]
+	return ok
+}
+
+// localRoom is the room as this node sees it: its slots, the local sockets and the remote ones it heard of. Needs Mu.
+func (h *Hub) localRoom(roomId string) store.Room {
+	room := store.Room{ID: roomId, Connected: make(map[string]string)}
+	if opts := h.Options[roomId]; opts != nil {
+		room.Options = *opts
+	}
+	for clientId, c := range h.Rooms[roomId] {
+		room.Clients = append(room.Clients, clientId)
+		if c != nil {
+			room.Connected[clientId] = h.node
+		} else if node := h.remote[roomId][clientId]; node != "" {
+			room.Connected[clientId] = node
+		}
+	}
+	sort.Strings(room.Clients)
+	return room
+}
+
+// cacheRemote takes the remote sockets of a room just read from the store. Needs Mu.
+func (h *Hub) cacheRemote(room store.Room) {
+	if h.Rooms[room.ID] == nil {
+		return
+	}
+	remote := make(map[string]string)
+	for _, clientId := range room.Clients {
+		if _, ok := h.Rooms[room.ID][clientId]; !ok {
+			h.Rooms[room.ID][clientId] = nil // reserved on another node
+		}
+		if node := room.Connected[clientId]; node != "" && node != h.node {
+			remote[clientId] = node
+		}
+	}
+	h.remote[room.ID] = remote
+}
@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"signaling-server-webrtc/pkg/bus"
+	"signaling-server-webrtc/pkg/signal"
+	"signaling-server-webrtc/pkg/store"
+	"signaling-server-webrtc/pkg/types"
+)
+
+// two hubs sharing a store and an in-process bus, like two instances behind one Redis
+func newLocalCluster(t *testing.T) (n1, n2 *Hub) {
+	t.Helper()
+	st := store.NewMemory()
+	b := bus.NewLocal()
+	n1, n2 = NewHub(st, b.Join("n1")), NewHub(st, b.Join("n2"))
+	go n1.Run()
+	go n2.Run()
+	return n1, n2
+}
+
+// the same with the Redis store and bus, against miniredis
+func newRedisCluster(t *testing.T) (n1, n2 *Hub) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	node := func(id string) *Hub {
+		st, err := store.NewRedis("redis://"+mr.Addr(), "test:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := bus.NewRedis("redis://"+mr.Addr(), "test:bus", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { b.Close(); st.Close() })
+		h := NewHub(st, b)
+		go h.Run()
+		return h
+	}
+	return node("n1"), node("n2")
+}
+
+// forEachCluster runs test against both buses
+func forEachCluster(t *testing.T, test func(t *testing.T, n1, n2 *Hub)) {
+	for name, newCluster := range map[string]func(*testing.T) (*Hub, *Hub){"local": newLocalCluster, "redis": newRedisCluster} {
+		t.Run(name, func(t *testing.T) {
+			n1, n2 := newCluster(t)
+			test(t, n1, n2)
+		})
+	}
+}
+
+// receive waits for the next message of type msgType on c, skipping others
+func receive(t *testing.T, c *Client, msgType string) signal.Message {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case raw, ok := <-c.Send:
+			if !ok {
+				t.Fatalf("%s: Send closed waiting for %q", c.ClientId, msgType)
+			}
+			if msg, ok := signal.Parse(raw); ok && msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("%s: no %q message", c.ClientId, msgType)
+		}
+	}
+}
+
+// eventually polls cond, the bus delivers asynchronously
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func knowsSlot(h *Hub, roomId, clientId string) bool {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	_, ok := h.Rooms[roomId][clientId]
+	return ok
+}
+
+func TestClusterRolesAndRelay(t *testing.T) {
+	forEachCluster(t, func(t *testing.T, n1, n2 *Hub) {
+
+		if err := n1.CreateRoom("r1", types.RoomOptions{}, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if err := n2.ReserveSlot("r1", "b"); err != nil {
+			t.Fatal(err)
+		}
+		a, b := NewBotClient("r1", "a"), NewBotClient("r1", "b")
+		n1.Register <- a
+		n2.Register <- b
+
+		// both connected, on different nodes: one of them gets each role
+		roleA, roleB := receive(t, a, "role"), receive(t, b, "role")
+		if string(roleA.Data) != `{"role":"offerer"}` || string(roleB.Data) != `{"role":"answerer"}` {
+			t.Errorf("roles: a %s, b %s", roleA.Data, roleB.Data)
+		}
+
+		n1.HandleMessage(a, []byte(`{"type":"relay","data":{"text":"a to b"}}`))
+		if msg := receive(t, b, "relay"); !strings.Contains(string(msg.Data), "a to b") {
+			t.Errorf("b got %s", msg.Data)
+		}
+		n2.HandleMessage(b, []byte(`{"type":"relay","data":{"text":"b to a"}}`))
+		if msg := receive(t, a, "relay"); !strings.Contains(string(msg.Data), "b to a") {
+			t.Errorf("a got %s", msg.Data)
+		}
+	})
+}
+
+func TestClusterPendingForwarded(t *testing.T) {
+	forEachCluster(t, func(t *testing.T, n1, n2 *Hub) {
+
+		if err := n1.CreateRoom("r1", types.RoomOptions{}, "a"); err != nil {
+			t.Fatal(err)
+		}
+		a := NewBotClient("r1", "a")
+		n1.Register <- a
+
+		// b reserves on n2, n1 hears of the slot over the bus and holds what a sends for it
+		if err := n2.ReserveSlot("r1", "b"); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, "n1 to learn about slot b", func() bool { return knowsSlot(n1, "r1", "b") })
+		n1.HandleMessage(a, []byte(`{"type":"relay","data":{"text":"before b connected"}}`))
+
+		// b connects on n2: n1 forwards the held message
+		b := NewBotClient("r1", "b")
+		n2.Register <- b
+		if msg := receive(t, b, "relay"); !strings.Contains(string(msg.Data), "before b connected") {
+			t.Errorf("b got %s", msg.Data)
+		}
+	})
+}
+
+func TestClusterPresenceCache(t *testing.T) {
+	forEachCluster(t, func(t *testing.T, n1, n2 *Hub) {
+
+		if err := n1.CreateRoom("r1", types.RoomOptions{}, "a", "b"); err != nil {
+			t.Fatal(err)
+		}
+		a, b := NewBotClient("r1", "a"), NewBotClient("r1", "b")
+		n1.Register <- a
+		n2.Register <- b
+		receive(t, a, "role")
+
+		remoteNode := func() string {
+			n1.Mu.RLock()
+			defer n1.Mu.RUnlock()
+			return n1.localRoom("r1").Connected["b"]
+		}
+		eventually(t, "n1 to see b on n2", func() bool { return remoteNode() == "n2" })
+
+		n2.Unregister <- b
+		eventually(t, "n1 to see b leave", func() bool { return remoteNode() == "" })
+		if !knowsSlot(n1, "r1", "b") {
+			t.Error("b's slot is gone during its reconnect grace")
+		}
+	})
+}
+
+func TestClusterPostForwarded(t *testing.T) {
+	forEachCluster(t, func(t *testing.T, n1, n2 *Hub) {
+		if err := n1.CreateRoom("r1", types.RoomOptions{}, "a", "b"); err != nil {
+			t.Fatal(err)
+		}
+		a := NewBotClient("r1", "a")
+		n1.Register <- a
+		b, stream := NewStreamClient("r1", "b")
+		n2.Register <- b
+		b.Start(n2)
+		receive(t, a, "role")
+
+		// b's POST reaches n1, which does not hold its stream
+		if err := n1.PostMessage(context.Background(), "r1", "b", []byte(`{"type":"relay","data":{"text":"posted on n1"}}`)); err != nil {
+			t.Fatal(err)
+		}
+		if msg := receive(t, a, "relay"); !strings.Contains(string(msg.Data), "posted on n1") {
+			t.Errorf("a got %s", msg.Data)
+		}
+
+		stream.Close()
+		eventually(t, "b to leave", func() bool {
+			return n1.PostMessage(context.Background(), "r1", "b", []byte(`{}`)) == ErrNoStream
+		})
+	})
+}
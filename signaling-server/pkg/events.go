@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"signaling-server-webrtc/pkg/types"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+Observers (dashboards, signalctl events) subscribe to what the hub does instead of
+reading LogRoom output: rooms created/deleted, slots reserved/connected/disconnected/
+released, roles, wait timeouts, and every EVENTS_RELAY_INTERVAL the relay messages per
+room. Each instance streams its own events, Event.Node tells them apart.
+
+emit never blocks the hub: a subscriber that does not keep up with EVENTS_BUFFER
+events loses the next ones and is told how many with an "events-dropped" event.
+*/
+var (
+	eventBuffer        = utils.GetEnvInt("EVENTS_BUFFER", 256)
+	relayEventInterval = utils.GetEnvDuration("EVENTS_RELAY_INTERVAL", 5*time.Second)
+)
+
+type eventFeed struct {
+	active atomic.Int32 // subscriptions, emit returns right away without any
+
+	mu        sync.Mutex
+	subs      map[*Subscription]struct{}
+	closed    bool
+	relay     map[string]*relayCount // roomId -> relayed since lastRelay
+	lastRelay time.Time
+}
+
+type relayCount struct {
+	messages int
+	bytes    int
+}
+
+// Subscription receives the events of one room, or all rooms, on C until Close
+type Subscription struct {
+	C <-chan types.Event
+
+	c       chan types.Event
+	roomId  string
+	types   map[string]bool // nil: all
+	dropped atomic.Int64
+	feed    *eventFeed
+}
+
+// Subscribe starts receiving events, roomId "" for every room and no eventTypes for every type
+func (h *Hub) Subscribe(roomId string, eventTypes ...string) *Subscription {
+	s := &Subscription{c: make(chan types.Event, eventBuffer), roomId: roomId, feed: &h.events}
+	s.C = s.c
+	for _, t := range eventTypes {
+		if s.types == nil {
+			s.types = make(map[string]bool)
+		}
+		s.types[t] = true
+	}
+
+	f := &h.events
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		close(s.c)
+		return s
+	}
+	if f.subs == nil {
+		f.subs = make(map[*Subscription]struct{})
+	}
+	f.subs[s] = struct{}{}
+	f.active.Add(1)
+	return s
+}
+
+func (s *Subscription) Close() {
+	f := s.feed
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[s]; ok {
+		delete(f.subs, s)
+		f.active.Add(-1)
+		close(s.c)
+	}
+}
+
+// TakeDropped returns how many events were lost since the last call
+func (s *Subscription) TakeDropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+func (s *Subscription) wants(e *types.Event) bool {
+	return (s.roomId == "" || s.roomId == e.RoomID) && (s.types == nil || s.types[e.Type])
+}
+
+// CloseSubscriptions ends every stream, on shutdown so the server does not wait for them
+func (h *Hub) CloseSubscriptions() {
+	f := &h.events
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for s := range f.subs {
+		delete(f.subs, s)
+		close(s.c)
+	}
+	f.active.Store(0)
+}
+
+// emit hands an event to the subscribers, from any goroutine
+func (h *Hub) emit(eventType, roomId, clientId string, data map[string]any) {
+	f := &h.events
+	if f.active.Load() == 0 {
+		return
+	}
+	e := types.Event{Type: eventType, Time: time.Now(), Node: h.node, RoomID: roomId, ClientID: clientId, Data: data}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		if !s.wants(&e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// countRelay adds a message that passed the room's filters to its next "relay" event, runs in the sender's ReadPump
+func (h *Hub) countRelay(roomId string, size int) {
+	f := &h.events
+	if f.active.Load() == 0 {
+		return
+	}
+	f.mu.Lock()
+	if f.relay == nil {
+		f.relay = make(map[string]*relayCount)
+	}
+	n := f.relay[roomId]
+	if n == nil {
+		n = &relayCount{}
+		f.relay[roomId] = n
+	}
+	n.messages++
+	n.bytes += size
+	f.mu.Unlock()
+}
+
+// flushRelayCounts emits the relay counts once per EVENTS_RELAY_INTERVAL, called on every hub tick
+func (h *Hub) flushRelayCounts() {
+	f := &h.events
+	f.mu.Lock()
+	if len(f.relay) == 0 || time.Since(f.lastRelay) < relayEventInterval {
+		f.mu.Unlock()
+		return
+	}
+	counts := f.relay
+	f.relay, f.lastRelay = nil, time.Now()
+	f.mu.Unlock()
+
+	for roomId, n := range counts {
+		h.emit(types.EventRelay, roomId, "", map[string]any{"messages": n.messages, "bytes": n.bytes})
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/store"
+	"signaling-server-webrtc/pkg/types"
+	"signaling-server-webrtc/utils"
+)
+
+func HandleAdminListRooms(hub *pkg.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rooms, err := hub.AdminRooms()
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, map[string]any{"totalRooms": len(rooms), "rooms": rooms})
+	}
+}
+
+func HandleAdminRoom(hub *pkg.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room, err := hub.AdminRoom(mux.Vars(r)["roomId"])
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, room)
+	}
+}
+
+func HandleAdminCloseRoom(hub *pkg.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		action, err := decodeAdminAction(r)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+		roomId := mux.Vars(r)["roomId"]
+		if err := hub.CloseRoom(roomId, action.Reason); err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, map[string]string{"roomId": roomId, "status": "closed"})
+	}
+}
+
+func HandleAdminKick(hub *pkg.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		action, err := decodeAdminAction(r)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+		vars := mux.Vars(r)
+		if err := hub.KickClient(vars["roomId"], vars["clientId"], action.Reason); err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, map[string]string{"roomId": vars["roomId"], "clientId": vars["clientId"], "status": "kicked"})
+	}
+}
+
+// HandleAdminAnnounce sends to one room ({roomId} in the path) or to all of them
+func HandleAdminAnnounce(hub *pkg.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		action, err := decodeAdminAction(r)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+		if action.Message == "" {
+			utils.WriteError(w, http.StatusBadRequest, "message is required")
+			return
+		}
+		rooms, err := hub.Announce(mux.Vars(r)["roomId"], action.Message)
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, map[string]int{"rooms": rooms})
+	}
+}
+
+// decodeAdminAction reads the optional body, an empty one means no reason
+func decodeAdminAction(r *http.Request) (types.AdminAction, error) {
+	var action types.AdminAction
+	err := json.NewDecoder(r.Body).Decode(&action)
+	if errors.Is(err, io.EOF) {
+		return action, nil
+	}
+	return action, err
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, store.ErrRoomNotFound), errors.Is(err, pkg.ErrClientNotFound):
+		utils.WriteError(w, http.StatusNotFound, err.Error())
+	default:
+		utils.WriteError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/bot"
+	"signaling-server-webrtc/srv"
+	"signaling-server-webrtc/utils"
+)
+
+// HandleSpawnBot joins a server side bot into the room, like a client calling /api/rooms/join
+func HandleSpawnBot(hub *pkg.Hub, spawner *bot.Spawner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomId := r.URL.Query().Get("roomId")
+		name := r.URL.Query().Get("bot")
+		if roomId == "" {
+			utils.WriteError(w, http.StatusBadRequest, "invalid room id!")
+			return
+		}
+		if name == "" {
+			name = "echo"
+		}
+		if !bot.Known(name) {
+			utils.WriteError(w, http.StatusBadRequest, "unknown bot "+name)
+			return
+		}
+
+		room, err := srv.JoinRoom(hub, roomId)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		peer, err := spawner.Spawn(roomId, *room.ClientId, name)
+		if err != nil {
+			hub.ReleaseSlot(roomId, *room.ClientId) // give the reservation back
+			writeSpawnError(w, err, http.StatusBadRequest)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, map[string]string{
+			"roomId":   peer.RoomID,
+			"clientId": peer.ClientID,
+			"bot":      peer.Bot,
+		})
+	}
+}
+
+// writeSpawnError answers a refused Spawn, the server's bot limits get their own status
+func writeSpawnError(w http.ResponseWriter, err error, status int) {
+	switch {
+	case errors.Is(err, bot.ErrRateLimited):
+		w.Header().Set("Retry-After", "1")
+		status = http.StatusTooManyRequests
+	case errors.Is(err, bot.ErrTooManyBots):
+		status = http.StatusServiceUnavailable
+	}
+	utils.WriteError(w, status, err.Error())
+}
+
+// HandleListBots returns the names accepted by HandleSpawnBot
+func HandleListBots() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.WriteJSON(w, http.StatusOK, map[string][]string{"bots": bot.Names()})
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/ice"
+	"signaling-server-webrtc/utils"
+)
+
+// the TURN credentials in the response are short lived, they must not be cached.
+// Only a current reservation (roomId + clientId) gets TURN, anyone else just STUN:
+// the relay costs bandwidth and its shared secret must not be open to the internet.
+func HandleICEServers(hub *pkg.Hub, iceConfig *ice.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomId := r.URL.Query().Get("roomId")
+		clientId := r.URL.Query().Get("clientId")
+
+		servers := iceConfig.STUNServers()
+		if roomId != "" && clientId != "" && hub.IsReserved(roomId, clientId) {
+			servers = iceConfig.Servers(roomId, clientId)
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		utils.WriteJSON(w, http.StatusOK, map[string]any{
+			"iceServers": servers,
+			"ttl":        int(iceConfig.TTL.Seconds()),
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/ice"
 	"signaling-server-webrtc/srv"
 	"signaling-server-webrtc/utils"
 )
@@ -22,30 +23,39 @@ func HandleHealthCheck(serviceName string) http.HandlerFunc {
 	}
 }
 
-func HandleCreateRoom(hub *pkg.Hub) http.HandlerFunc {
+func HandleCreateRoom(hub *pkg.Hub, iceConfig *ice.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		room, err := srv.CreateRoom(hub)
+		opts, err := utils.DecodeRoomOptions(r)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "Invalid payload")
+			return
+		}
 
+		room, err := srv.CreateRoom(hub, opts)
 		if err != nil {
-			utils.WriteError(w, http.StatusInternalServerError, "could not create room")
+			utils.WriteError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		room.IceServers = iceConfig.Servers(*room.RoomId, *room.ClientId)
 
 		utils.WriteJSON(w, http.StatusOK, room)
 	}
 }
 
-func HandleJoinRoom(hub *pkg.Hub) http.HandlerFunc {
+func HandleJoinRoom(hub *pkg.Hub, iceConfig *ice.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		roomId := r.URL.Query().Get("roomId")
 
 		if roomId == "" {
 			utils.WriteError(w, http.StatusBadRequest, "invalid room id!")
+			return
 		}
 		room, err := srv.JoinRoom(hub, roomId)
 		if err != nil {
 			utils.WriteError(w, http.StatusBadRequest, err.Error())
+			return
 		}
+		room.IceServers = iceConfig.Servers(*room.RoomId, *room.ClientId)
 
 		utils.WriteJSON(w, http.StatusOK, room)
 	}
@@ -81,6 +91,14 @@ func HandleJoinRoom(hub *pkg.Hub) http.HandlerFunc {
 // 	}
 // }
 
+// RoomParticipant accepts requests (stats, bot spawn) of a room's own clients: ?roomId=...&clientId=... with a slot in it
+func RoomParticipant(hub *pkg.Hub) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		roomId, clientId := r.URL.Query().Get("roomId"), r.URL.Query().Get("clientId")
+		return roomId != "" && clientId != "" && hub.IsReserved(roomId, clientId)
+	}
+}
+
 func HandleRoomStats(hub *pkg.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		roomId := r.URL.Query().Get("roomId")
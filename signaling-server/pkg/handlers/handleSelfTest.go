@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/bot"
+	"signaling-server-webrtc/pkg/ice"
+	"signaling-server-webrtc/pkg/types"
+	"signaling-server-webrtc/srv"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+HandleSelfTest creates a private room with a loopback bot in it. The caller connects
+like after /api/rooms/create, is told to be the offerer and gets its data channel
+messages and media back, plus "selftest-report" messages with the selected candidate
+pair and the round trip time. The bot leaves after maxDuration at the latest.
+*/
+func HandleSelfTest(hub *pkg.Hub, iceConfig *ice.Config, spawner *bot.Spawner, maxDuration time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room, peerId, err := srv.CreatePrivateRoom(hub)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		peer, err := spawner.Spawn(*room.RoomId, peerId, "loopback")
+		if err != nil {
+			hub.DeleteRoom(*room.RoomId)
+			writeSpawnError(w, err, http.StatusInternalServerError)
+			return
+		}
+		time.AfterFunc(maxDuration, peer.Leave)
+
+		room.IceServers = iceConfig.Servers(*room.RoomId, *room.ClientId)
+
+		// the usual room reservation, plus who the caller is going to talk to
+		utils.WriteJSON(w, http.StatusOK, struct {
+			types.Room
+			PeerId         string `json:"peerId"`
+			MaxDurationSec int    `json:"maxDurationSec"`
+		}{room, peerId, int(maxDuration.Seconds())})
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/ice"
+	"signaling-server-webrtc/pkg/sfu"
+	"signaling-server-webrtc/pkg/signal"
+	"signaling-server-webrtc/utils"
+)
+
+const maxSDPBody = 1 << 20 // the SDP policy has its own (smaller) limit, this only protects the read
+
+/*
+HandleWHIPOffer / HandleWHEPOffer take the POST of a WHIP publisher or WHEP viewer:
+
+	POST /api/whip/{roomId}
+	Authorization: Bearer <ClientId>
+	Content-Type: application/sdp
+
+201 with the answer (all our candidates included), the session URL in Location
+and the ICE servers in Link headers.
+*/
+func HandleWHIPOffer(hub *pkg.Hub, iceConfig *ice.Config) http.HandlerFunc {
+	return handleHTTPOffer(hub, iceConfig, "whip", hub.StartWHIP)
+}
+
+func HandleWHEPOffer(hub *pkg.Hub, iceConfig *ice.Config) http.HandlerFunc {
+	return handleHTTPOffer(hub, iceConfig, "whep", hub.StartWHEP)
+}
+
+func handleHTTPOffer(hub *pkg.Hub, iceConfig *ice.Config, kind string, start func(roomId, clientId, offer string) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomId := mux.Vars(r)["roomId"]
+		clientId := bearerToken(r)
+		if clientId == "" {
+			utils.WriteError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		if !hasContentType(r, "application/sdp") {
+			utils.WriteError(w, http.StatusUnsupportedMediaType, "expected application/sdp")
+			return
+		}
+		offer, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSDPBody))
+		if err != nil {
+			utils.WriteError(w, http.StatusRequestEntityTooLarge, "offer too large")
+			return
+		}
+
+		answer, err := start(roomId, clientId, string(offer))
+		if err != nil {
+			writeHTTPSessionError(w, err)
+			return
+		}
+
+		for _, server := range iceConfig.Servers(roomId, clientId) {
+			for _, url := range server.URLs {
+				w.Header().Add("Link", iceServerLink(url, server.Username, server.Credential))
+			}
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/%s/%s/%s", kind, roomId, clientId))
+		w.Header().Set("Content-Type", "application/sdp")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(answer))
+	}
+}
+
+// HandleHTTPSessionPatch takes trickled candidates (application/trickle-ice-sdpfrag) for a WHIP/WHEP session
+func HandleHTTPSessionPatch(hub *pkg.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomId, clientId, ok := sessionFromRequest(w, r)
+		if !ok {
+			return
+		}
+		if !hasContentType(r, "application/trickle-ice-sdpfrag") {
+			utils.WriteError(w, http.StatusUnsupportedMediaType, "expected application/trickle-ice-sdpfrag")
+			return
+		}
+		frag, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSDPBody))
+		if err != nil {
+			utils.WriteError(w, http.StatusRequestEntityTooLarge, "body too large")
+			return
+		}
+
+		if err := hub.TrickleHTTPSession(roomId, clientId, string(frag)); err != nil {
+			writeHTTPSessionError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleHTTPSessionDelete ends a WHIP/WHEP session
+func HandleHTTPSessionDelete(hub *pkg.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roomId, clientId, ok := sessionFromRequest(w, r)
+		if !ok {
+			return
+		}
+		if err := hub.StopHTTPSession(roomId, clientId); err != nil {
+			writeHTTPSessionError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// sessionFromRequest reads the session URL, only the token of its own client may touch it
+func sessionFromRequest(w http.ResponseWriter, r *http.Request) (roomId, clientId string, ok bool) {
+	vars := mux.Vars(r)
+	if token := bearerToken(r); token == "" || token != vars["clientId"] {
+		utils.WriteError(w, http.StatusUnauthorized, "invalid bearer token")
+		return "", "", false
+	}
+	return vars["roomId"], vars["clientId"], true
+}
+
+func writeHTTPSessionError(w http.ResponseWriter, err error) {
+	var sdpErr *signal.Error
+	switch {
+	case errors.As(err, &sdpErr):
+		utils.WriteError(w, http.StatusBadRequest, sdpErr.Error())
+	case errors.Is(err, pkg.ErrNotReserved):
+		utils.WriteError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, pkg.ErrRoomNotFound), errors.Is(err, sfu.ErrNoSession):
+		utils.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, pkg.ErrNotSFURoom), errors.Is(err, sfu.ErrSessionExists):
+		utils.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		utils.WriteError(w, http.StatusBadRequest, err.Error()) // pion refusing the offer
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func hasContentType(r *http.Request, want string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == want
+}
+
+// iceServerLink formats one ICE server the way WHIP clients expect it (RFC 9725 section 4.6)
+func iceServerLink(url, username, credential string) string {
+	link := fmt.Sprintf(`<%s>; rel="ice-server"`, url)
+	if username != "" {
+		link += fmt.Sprintf(`; username="%s"; credential="%s"; credential-type="password"`, username, credential)
+	}
+	return link
+}
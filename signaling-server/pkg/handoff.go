@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"net"
+
+	"signaling-server-webrtc/utils"
+)
+
+/*
+A handoff moves this node's rooms and their live WebSockets to a new process (the
+transfer itself is in pkg/handoff, Linux only). Detach stops Run, stops every client's
+pumps without closing its socket or leaving the room, and returns the sockets with a
+Snapshot numbering them. Server side bots and SFU media stay behind.
+*/
+type Handover struct {
+	Snapshot Snapshot
+	Conns    []net.Conn // ClientSnapshot.Conn indexes this
+}
+
+// Detach ends Run and gives this node's rooms away, the process should exit afterwards
+func (h *Hub) Detach() Handover {
+	reply := make(chan Handover)
+	h.detach <- reply
+	return <-reply
+}
+
+func (h *Hub) handOver() Handover {
+	var clients []*Client
+	h.Mu.RLock()
+	for _, room := range h.Rooms {
+		for _, c := range room {
+			if c != nil && c.webSocket() != nil { // SSE streams reconnect within the grace
+				clients = append(clients, c)
+			}
+		}
+	}
+	h.Mu.RUnlock()
+
+	// a ReadPump may be stuck handing a message to Run, keep relaying until every one stopped.
+	// The WritePumps go on meanwhile, a full Send would block the relaying otherwise.
+	stopped := make(chan struct{})
+	go func() {
+		for _, c := range clients {
+			c.stopReading()
+		}
+		close(stopped)
+	}()
+	for done := false; !done; {
+		select {
+		case <-stopped:
+			done = true
+		case msg := <-h.Broadcast:
+			h.sendToRoom(msg)
+		case <-h.Unregister: // a bot leaving, it stays behind anyway
+		}
+	}
+	for _, c := range clients {
+		c.stopWriting()
+	}
+
+	var ho Handover
+	index := make(map[*Client]int)
+	for _, c := range clients {
+		if !c.resumable() {
+			utils.LogRoom(c.RoomID, c.ClientId, "socket stopped mid frame, not handed off")
+			c.transport.Close() // it reconnects within the grace
+			continue
+		}
+		index[c] = len(ho.Conns)
+		ho.Conns = append(ho.Conns, c.webSocket().NetConn())
+	}
+	ho.Snapshot = h.snapshot(func(c *Client) int {
+		if i, ok := index[c]; ok {
+			return i
+		}
+		return -1
+	})
+	return ho
+}
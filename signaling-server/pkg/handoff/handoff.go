@@ -0,0 +1,50 @@
+/*
+Package handoff passes the listening socket, open connections and a state blob from a
+running server to its successor over a Unix socket (SCM_RIGHTS). Linux only.
+
+	old: l := handoff.Listen(path) ... l.Handover(prepare)  waits, sends, then exits
+	new: handoff.Take(path)                                  connects, receives, acks
+
+The successor takes over before it listens on path itself, so at any time one process
+serves and one path is in use.
+*/
+package handoff
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+var (
+	ErrUnsupported   = errors.New("socket handoff is only supported on Linux")
+	ErrNoPredecessor = errors.New("no running server to take over from")
+)
+
+// Transfer is what goes from one process to the next
+type Transfer struct {
+	Listener *os.File
+	Conns    []*os.File
+	State    []byte
+}
+
+const (
+	fdBatch   = 64       // descriptors per message, the kernel caps it at 253
+	chunkSize = 32 << 10 // state bytes per message
+	// the old process finishes its in-flight requests first
+	transferTimeout = 30 * time.Second
+)
+
+type header struct {
+	Conns int `json:"conns"`
+	State int `json:"state"`
+}
+
+func (t Transfer) Close() {
+	if t.Listener != nil {
+		t.Listener.Close()
+	}
+	for _, f := range t.Conns {
+		f.Close()
+	}
+}
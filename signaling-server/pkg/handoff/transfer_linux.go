@@ -0,0 +1,161 @@
+//go:build linux
+
+package handoff
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+/*
+One SOCK_SEQPACKET connection, message boundaries kept:
+
+	old -> new  {"conns":N,"state":M}
+	old -> new  "fds" + SCM_RIGHTS, listener first, fdBatch at a time
+	old -> new  state, chunkSize at a time
+	new -> old  "ok"
+*/
+
+// Listener waits for the successor of this process
+type Listener struct {
+	l *net.UnixListener
+}
+
+func Listen(path string) (*Listener, error) {
+	os.Remove(path) // left by the predecessor, it does not unlink it
+	l, err := net.ListenUnix("unixpacket", &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false) // the successor listens on the same path by then
+	return &Listener{l: l}, nil
+}
+
+// Handover blocks until a successor connects, then sends it what prepare returns. After a nil
+// error the successor owns everything and the caller should exit.
+func (l *Listener) Handover(prepare func() (Transfer, error)) error {
+	conn, err := l.l.AcceptUnix()
+	l.l.Close() // one successor
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	t, err := prepare()
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(transferTimeout))
+	return send(conn, t)
+}
+
+func send(conn *net.UnixConn, t Transfer) error {
+	hdr, _ := json.Marshal(header{Conns: len(t.Conns), State: len(t.State)})
+	if _, err := conn.Write(hdr); err != nil {
+		return err
+	}
+
+	files := append([]*os.File{t.Listener}, t.Conns...)
+	for i := 0; i < len(files); i += fdBatch {
+		batch := files[i:min(i+fdBatch, len(files))]
+		fds := make([]int, len(batch))
+		for j, f := range batch {
+			fds[j] = int(f.Fd())
+		}
+		if _, _, err := conn.WriteMsgUnix([]byte("fds"), syscall.UnixRights(fds...), nil); err != nil {
+			return err
+		}
+	}
+
+	for off := 0; off < len(t.State); off += chunkSize {
+		if _, err := conn.Write(t.State[off:min(off+chunkSize, len(t.State))]); err != nil {
+			return err
+		}
+	}
+
+	ack := make([]byte, 8)
+	n, err := conn.Read(ack)
+	if err != nil {
+		return err
+	}
+	if string(ack[:n]) != "ok" {
+		return fmt.Errorf("successor answered %q", ack[:n])
+	}
+	return nil
+}
+
+// Take connects to the running server on path and receives its sockets and state,
+// ErrNoPredecessor when nobody listens there
+func Take(path string) (Transfer, error) {
+	conn, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: path, Net: "unixpacket"})
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+		return Transfer{}, ErrNoPredecessor
+	}
+	if err != nil {
+		return Transfer{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(transferTimeout))
+
+	buf := make([]byte, chunkSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return Transfer{}, err
+	}
+	var hdr header
+	if err := json.Unmarshal(buf[:n], &hdr); err != nil {
+		return Transfer{}, fmt.Errorf("bad handoff header: %w", err)
+	}
+
+	var files []*os.File
+	fail := func(err error) (Transfer, error) {
+		for _, f := range files {
+			f.Close()
+		}
+		return Transfer{}, err
+	}
+	oob := make([]byte, syscall.CmsgSpace(fdBatch*4))
+	for len(files) < hdr.Conns+1 {
+		_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return fail(err)
+		}
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return fail(err)
+		}
+		received := 0
+		for i := range msgs {
+			fds, err := syscall.ParseUnixRights(&msgs[i])
+			if err != nil {
+				return fail(err)
+			}
+			for _, fd := range fds {
+				files = append(files, os.NewFile(uintptr(fd), "handoff"))
+			}
+			received += len(fds)
+		}
+		if received == 0 {
+			return fail(errors.New("handoff message without descriptors"))
+		}
+	}
+
+	state := make([]byte, 0, hdr.State)
+	for len(state) < hdr.State {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return fail(err)
+		}
+		state = append(state, buf[:n]...)
+	}
+
+	if _, err := conn.Write([]byte("ok")); err != nil {
+		return fail(err)
+	}
+	return Transfer{Listener: files[0], Conns: files[1:], State: state}, nil
+}
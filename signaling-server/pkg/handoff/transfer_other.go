@@ -0,0 +1,17 @@
+//go:build !linux
+
+package handoff
+
+type Listener struct{}
+
+func Listen(path string) (*Listener, error) {
+	return nil, ErrUnsupported
+}
+
+func (l *Listener) Handover(prepare func() (Transfer, error)) error {
+	return ErrUnsupported
+}
+
+func Take(path string) (Transfer, error) {
+	return Transfer{}, ErrUnsupported
+}
@@ -4,7 +4,12 @@ import (
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 
+	"signaling-server-webrtc/pkg/bus"
+	"signaling-server-webrtc/pkg/sfu"
+	"signaling-server-webrtc/pkg/signal"
+	"signaling-server-webrtc/pkg/store"
 	"signaling-server-webrtc/pkg/types"
 	"signaling-server-webrtc/utils"
 )
@@ -17,7 +22,7 @@ Hub is the Central Event Manager. Responsible for:
   - Cleaning up connections when clients disconnect
   - Acting as a message router using Go channels for concurrency safety
 
-Hub.Rooms structure:
+Hub.Rooms structure (this node's view, reservations themselves are in the RoomStore, see rooms.go):
 
 	{
 		"roomId1": {
@@ -32,24 +37,81 @@ Notes:
   - A room is represented as a map of ClientId → *Client.
   - Empty rooms are removed to free up memory.
   - A Client may be pre-registered (with nil connection) via REST before WebSocket connects.
+  - Options caches the per room settings of the store, guarded by Mu like Rooms.
+  - remote caches who is connected on the other nodes (see cluster.go), guarded by Mu too.
+    Run relays with it, the store is only asked when a client connects or leaves, never with Mu held.
+  - timers, waiting and pending are only touched from the Run goroutine.
 */
 type Hub struct {
 	Rooms      map[string]map[string]*Client
+	Options    map[string]*types.RoomOptions
+	remote     map[string]map[string]string // roomId -> ClientId -> node, for sockets held by other nodes
 	Register   chan *Client
 	Unregister chan *Client
 	Broadcast  chan MessageEnvelope
 	Mu         sync.RWMutex
+
+	timers      *TimerWheel
+	waiting     map[string]*waitState               // roomId -> since when a client waits alone
+	pending     map[string]map[string]*pendingQueue // roomId -> ClientId -> messages sent before it connected
+	defaultWait types.WaitPolicy
+	sdpPolicy   signal.SDPPolicy
+	candidates  signal.CandidatePolicy // default for rooms created without candidatePolicy
+	relayUsage  func(roomId string) *types.RelayUsage
+	sfu         *sfu.SFU  // nil when SFU rooms are disabled
+	sfuQueue    workQueue // joins and leaves, see joinSFU
+	store       store.RoomStore
+	bus         bus.Bus // to the other instances, see cluster.go
+	node        string
+	ownsRoomID  func(roomId string) bool // nil: any id, see SetRoomOwnership
+	detach      chan chan Handover       // see Detach
+	snapshots   chan chan Snapshot       // see Snapshot
+	calls       chan func()              // admin actions, see admin.go
+	events      eventFeed                // see Subscribe
+	relayLimits relayLimits              // see checkRelay
 }
 
-func NewHub() *Hub {
+const hubTick = time.Second
+
+func NewHub(roomStore store.RoomStore, b bus.Bus) *Hub {
 	return &Hub{
 		Rooms:      make(map[string]map[string]*Client),
+		Options:    make(map[string]*types.RoomOptions),
+		remote:     make(map[string]map[string]string),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Broadcast:  make(chan MessageEnvelope),
+
+		timers:  NewTimerWheel(hubTick, 60),
+		waiting: make(map[string]*waitState),
+		pending: make(map[string]map[string]*pendingQueue),
+		defaultWait: types.WaitPolicy{
+			FirstReminderSec: int(utils.GetEnvDuration("ROOM_WAIT_FIRST_REMINDER", time.Minute).Seconds()),
+			RepeatEverySec:   int(utils.GetEnvDuration("ROOM_WAIT_REPEAT", 0).Seconds()),
+			CloseAfterSec:    int(utils.GetEnvDuration("ROOM_WAIT_CLOSE_AFTER", 0).Seconds()),
+		},
+		sdpPolicy:  signal.SDPPolicyFromEnv(),
+		candidates: candidatePolicyFromEnv(),
+		store:      roomStore,
+		bus:        b,
+		node:       b.Node(),
+		detach:     make(chan chan Handover),
+		snapshots:  make(chan chan Snapshot),
+		calls:      make(chan func()),
 	}
 }
 
+// SetRelayUsageSource plugs the embedded TURN server's per room usage into RoomStats
+func (h *Hub) SetRelayUsageSource(source func(roomId string) *types.RelayUsage) {
+	h.relayUsage = source
+}
+
+// IsReserved tells if ClientId has a slot in the room, connected (on any node) or still a placeholder
+func (h *Hub) IsReserved(roomID, clientId string) bool {
+	room, err := h.store.Lookup(roomID)
+	return err == nil && room.Has(clientId)
+}
+
 // it will traverse the Hub to get client ptr from ClientId if it is present in roomId
 func (h *Hub) GetClientFromRoom(roomID, clientId string) *Client {
 	h.Mu.RLock()
@@ -64,111 +126,321 @@ func (h *Hub) GetClientFromRoom(roomID, clientId string) *Client {
 }
 
 func (h *Hub) Run() {
+	ticker := time.NewTicker(hubTick)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case c := <-h.Register: // get value(client) from Register channel
-			h.addClient(c) // add client to the hub
-			h.assignClientRole(c.RoomID)
+			room := h.addClient(c) // add client to the hub
+			h.flushPending(c)      // deliver what the peer sent before this socket existed
+			if h.usesSFU(c.RoomID) {
+				h.joinSFU(c) // the server is the other side of the negotiation
+			}
+			if h.presenter(c.RoomID) != "" {
+				h.broadcastJoined(c, room) // presenter/viewer roles
+			} else if !h.usesSFU(c.RoomID) {
+				h.assignClientRole(room)
+			}
+			h.updateWaiting(c.RoomID, room.Connected)
 		case c := <-h.Unregister: // get value from Unregister channel
-			h.removeClient(c) // remove client from the hub
+			if h.GetClientFromRoom(c.RoomID, c.ClientId) != c {
+				continue // replaced by a newer socket of the same client, or closed with its room
+			}
+			if h.usesSFU(c.RoomID) {
+				h.leaveSFU(c.RoomID, c.ClientId)
+			}
+			h.broadcastLeft(c)
+			room := h.removeClient(c, reconnectGrace > 0) // remove client from the hub
+			h.updateWaiting(c.RoomID, room.Connected)
 		case msg := <-h.Broadcast: // get value from Broadcast channel
 			h.sendToRoom(msg) // send message to the room
+		case m := <-h.bus.Messages(): // what the other instances published
+			h.fromBus(m)
+		case <-ticker.C:
+			h.timers.Advance() // fire due timers (wait reminders, auto close)
+			h.flushRelayCounts()
+		case fn := <-h.calls:
+			fn()
+		case reply := <-h.snapshots:
+			reply <- h.snapshot(func(*Client) int { return -1 })
+		case reply := <-h.detach:
+			reply <- h.handOver()
+			return // the next process runs the rooms now
 		}
 	}
 }
 
-func (h *Hub) addClient(c *Client) {
-	h.Mu.Lock()
-	defer h.Mu.Unlock()
+// addClient makes c the live socket of its slot and returns the room with everybody connected
+func (h *Hub) addClient(c *Client) store.Room {
+	h.hydrate(c.RoomID)
 
+	h.Mu.Lock()
 	if h.Rooms[c.RoomID] == nil {
 		h.Rooms[c.RoomID] = make(map[string]*Client)
 	}
+	if old := h.Rooms[c.RoomID][c.ClientId]; old != nil {
+		close(old.Send) // the client reconnected before its old socket timed out, the new one wins
+	}
 	h.Rooms[c.RoomID][c.ClientId] = c
+	delete(h.remote[c.RoomID], c.ClientId)
+	h.Mu.Unlock()
+
+	reconnected := h.timers.Pending(graceTimerKey(c.RoomID, c.ClientId))
+	h.timers.Cancel(graceTimerKey(c.RoomID, c.ClientId))
 	fmt.Println(c.RoomID, c.ClientId, "✅ Joined room")
-}
 
-func (h *Hub) removeClient(c *Client) {
+	h.touchRoom(c.RoomID)
+	room, err := h.store.Connect(c.RoomID, c.ClientId, h.node)
 	h.Mu.Lock()
-	defer h.Mu.Unlock()
+	if err != nil {
+		utils.LogRoom(c.RoomID, c.ClientId, "room store: connect failed: %s", err)
+		room = h.localRoom(c.RoomID)
+	} else {
+		h.cacheRemote(room)
+	}
+	h.Mu.Unlock()
+	h.publish(bus.Message{Kind: bus.KindJoined, RoomID: c.RoomID, From: c.ClientId})
+	h.emit(types.EventClientConnected, c.RoomID, c.ClientId, map[string]any{"reconnected": reconnected})
+	return room
+}
 
-	if _, ok := h.Rooms[c.RoomID][c.ClientId]; ok {
-		delete(h.Rooms[c.RoomID], c.ClientId)
-		close(c.Send)
+// removeClient frees or holds c's slot and returns the room with the clients still connected
+func (h *Hub) removeClient(c *Client, keepSlot bool) store.Room {
+	h.Mu.Lock()
+	if h.Rooms[c.RoomID][c.ClientId] != c {
+		defer h.Mu.Unlock()
+		return h.localRoom(c.RoomID)
 	}
+	close(c.Send)
+	h.Rooms[c.RoomID][c.ClientId] = nil // a placeholder until the slot is held or released below
+	h.Mu.Unlock()
 	utils.LogRoom(c.RoomID, c.ClientId, "❌ Left room")
 
+	h.touchRoom(c.RoomID)
+	room, err := h.store.Disconnect(c.RoomID, c.ClientId, h.node)
+	h.emit(types.EventClientDisconnected, c.RoomID, c.ClientId, map[string]any{"held": keepSlot})
+	if keepSlot {
+		h.holdSlot(c.RoomID, c.ClientId, reconnectGrace)
+	} else {
+		h.deleteSlot(c.RoomID, c.ClientId)
+	}
+
+	h.Mu.Lock()
+	if err != nil {
+		room = h.localRoom(c.RoomID)
+	} else {
+		h.cacheRemote(room)
+	}
+	h.Mu.Unlock()
+	h.publish(bus.Message{Kind: bus.KindLeft, RoomID: c.RoomID, From: c.ClientId})
+	return room
+}
+
+// deleteSlot drops the client's reservation and the room with its last one. Takes Mu.
+func (h *Hub) deleteSlot(roomId, clientId string) {
+	if err := h.store.Release(roomId, clientId); err != nil {
+		utils.LogRoom(roomId, clientId, "room store: release failed: %s", err)
+	}
+
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+
+	delete(h.Rooms[roomId], clientId)
+	delete(h.remote[roomId], clientId)
+	h.relayLimits.forget(roomId, clientId)
+	delete(h.pending[roomId], clientId)
+	h.emit(types.EventClientReleased, roomId, clientId, nil)
+
 	// Clean up room if empty
-	if len(h.Rooms[c.RoomID]) == 0 {
-		utils.LogRoom(c.RoomID, "Nil", "empty room! Deleting... 🗑️")
-		delete(h.Rooms, c.RoomID)
+	if len(h.Rooms[roomId]) == 0 {
+		utils.LogRoom(roomId, "Nil", "empty room! Deleting... 🗑️")
+		h.emit(types.EventRoomDeleted, roomId, "", map[string]any{"reason": "empty"})
+		delete(h.Rooms, roomId)
+		delete(h.Options, roomId)
+		delete(h.remote, roomId)
+		h.dropPending(roomId)
+	}
+}
+
+// closeRoom disconnects every client of the room, on every node, and deletes it. Must run in the Run goroutine.
+func (h *Hub) closeRoom(roomId string) {
+	if err := h.store.Delete(roomId); err != nil {
+		utils.LogRoom(roomId, "Nil", "room store: delete failed: %s", err)
+	}
+	h.dropRoom(roomId)
+	h.publish(bus.Message{Kind: bus.KindClosed, RoomID: roomId})
+	h.emit(types.EventRoomDeleted, roomId, "", map[string]any{"reason": "closed"})
+	utils.LogRoom(roomId, "Nil", "room closed 🗑️")
+}
+
+// dropRoom closes this node's sockets of the room and forgets it locally
+func (h *Hub) dropRoom(roomId string) {
+	isSFU := h.usesSFU(roomId)
+
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+
+	for clientId, c := range h.Rooms[roomId] {
+		if c != nil { // placeholders have nothing to close
+			close(c.Send) // WritePump closes the connection, ReadPump then unregisters a client that is already gone
+		}
+		if isSFU {
+			h.leaveSFU(roomId, clientId) // placeholders too, they may hold a WHIP/WHEP session
+		}
 	}
+	delete(h.Rooms, roomId)
+	delete(h.Options, roomId)
+	delete(h.remote, roomId)
+	h.relayLimits.forget(roomId, "")
+	h.dropPending(roomId)
+	delete(h.waiting, roomId)
+	h.timers.Cancel(waitTimerKey(roomId))
+}
+
+// connectedClients returns the clients with a live connection, placeholders are skipped
+func (h *Hub) connectedClients(roomId string) []*Client {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+
+	var clients []*Client
+	for _, c := range h.Rooms[roomId] {
+		if c != nil {
+			clients = append(clients, c)
+		}
+	}
+	return clients
 }
 
 func (h *Hub) sendToRoom(msg MessageEnvelope) {
 	h.Mu.RLock()
 	defer h.Mu.RUnlock()
 
-	for _, c := range h.Rooms[msg.RoomID] { // _ is ClientId
-		if c != msg.Sender {
+	if msg.Direct {
+		// only if the sender is still registered, otherwise its Send channel is already closed
+		if h.Rooms[msg.RoomID][msg.Sender.ClientId] == msg.Sender {
+			select {
+			case msg.Sender.Send <- msg.Data:
+			default:
+				utils.LogRoom(msg.RoomID, msg.Sender.ClientId, "Cannot send reply - channel unavailable")
+			}
+		}
+		return
+	}
+
+	// every slot of the room, also those connected to another node
+	room := h.localRoom(msg.RoomID)
+
+	if msg.To != "" {
+		c := h.Rooms[msg.RoomID][msg.To]
+		node, online := room.Connected[msg.To]
+		switch {
+		case c != nil:
 			c.Send <- msg.Data
+		case online && node != h.node:
+			h.publish(bus.Message{Kind: bus.KindSignal, RoomID: msg.RoomID, From: msg.Sender.ClientId, To: msg.To, Data: msg.Data})
+		case room.Has(msg.To):
+			h.queuePending(msg.RoomID, msg.To, msg.Data)
+		default:
+			utils.LogRoom(msg.RoomID, msg.Sender.ClientId, "Recipient %s left the room, message dropped", msg.To)
 		}
+		return
+	}
+
+	remote := false
+	for _, clientId := range room.Clients {
+		if clientId == msg.Sender.ClientId {
+			continue
+		}
+		c := h.Rooms[msg.RoomID][clientId]
+		node, online := room.Connected[clientId]
+		switch {
+		case c != nil:
+			c.Send <- msg.Data
+		case online && node != h.node:
+			remote = true
+		default: // reserved but not connected yet, hold it until Register
+			h.queuePending(msg.RoomID, clientId, msg.Data)
+		}
+	}
+	if remote {
+		h.publish(bus.Message{Kind: bus.KindSignal, RoomID: msg.RoomID, From: msg.Sender.ClientId, Data: msg.Data})
 	}
 	utils.LogRoom(msg.RoomID, msg.Sender.ClientId, "📡 Relaying message to other clients in room")
 }
 
-func (h *Hub) assignClientRole(roomId string) {
-	stats := h.RoomStats(roomId)
-	if len(stats.Clients) == 2 {
-		cIds := stats.Clients
-		sort.Strings(cIds)
-		offererClient, answererClient := cIds[0], cIds[1]
+// roles are handed out once both reserved clients are connected, a placeholder has nowhere to receive it.
+// room comes from the store's Connect, so of two clients connecting at once on two nodes only the second does it.
+func (h *Hub) assignClientRole(room store.Room) {
+	if len(room.Clients) == 2 && len(room.Connected) == 2 {
+		offererClient, answererClient := meshRoles(room)
+		h.deliver(room, offererClient, []byte(`{"type":"role","data":{"role":"offerer"}}`))
+		h.deliver(room, answererClient, []byte(`{"type":"role","data":{"role":"answerer"}}`))
+		h.emit(types.EventRoleAssigned, room.ID, offererClient, map[string]any{"role": "offerer"})
+		h.emit(types.EventRoleAssigned, room.ID, answererClient, map[string]any{"role": "answerer"})
+	}
+}
 
-		h.Mu.RLock()
-		defer h.Mu.RUnlock()
-		for clientId, clientPtr := range h.Rooms[roomId] {
+// meshRoles picks the offerer of a two slot room: the one set at creation, or the lower id
+func meshRoles(room store.Room) (offerer, answerer string) {
+	cIds := append([]string(nil), room.Clients...)
+	sort.Strings(cIds)
+	offerer, answerer = cIds[0], cIds[1]
 
-			switch clientId {
-			case offererClient:
-				clientPtr.Send <- []byte(`{"type":"role","data":{"role":"offerer"}}`)
-			case answererClient:
-				clientPtr.Send <- []byte(`{"type":"role","data":{"role":"answerer"}}`)
-			}
-		}
+	if room.Options.Offerer == answerer {
+		offerer, answerer = answerer, offerer
 	}
+	return offerer, answerer
 }
 
+// HubStats lists every room of the store, on whichever node its clients are connected
 func (hub *Hub) HubStats() types.HubStats {
-	hub.Mu.RLock()
-	defer hub.Mu.RUnlock()
+	rooms, err := hub.store.List()
+	if err != nil {
+		utils.LogRoom("Nil", "Nil", "room store: list failed: %s", err)
+	}
 
+	hub.Mu.RLock()
 	stats := types.HubStats{}
-	for roomID, clientsMap := range hub.Rooms {
-		roomStats := types.RoomStats{
-			RoomID: roomID,
-		}
-		for clientId := range clientsMap {
-			roomStats.Clients = append(roomStats.Clients, clientId)
-		}
-
-		stats.Rooms = append(stats.Rooms, roomStats)
+	for _, room := range rooms {
+		stats.Rooms = append(stats.Rooms, hub.roomStats(room))
 	}
 	stats.TotalRooms = len(stats.Rooms)
+	hub.Mu.RUnlock()
+
+	// asked outside of Mu, the TURN server takes its own lock and calls back into IsReserved
+	if hub.relayUsage != nil {
+		for i := range stats.Rooms {
+			stats.Rooms[i].Relay = hub.relayUsage(stats.Rooms[i].RoomID)
+		}
+	}
 
 	return stats
 }
 
 func (hub *Hub) RoomStats(roomId string) types.RoomStats {
-	hub.Mu.RLock()
-	defer hub.Mu.RUnlock()
-
-	roomData := hub.Rooms[roomId]
 	roomStats := types.RoomStats{
 		RoomID: roomId,
 	}
-	for clientIds := range roomData {
-		roomStats.Clients = append(roomStats.Clients, clientIds)
+	if room, err := hub.store.Lookup(roomId); err == nil {
+		hub.Mu.RLock()
+		roomStats = hub.roomStats(room)
+		hub.Mu.RUnlock()
+	}
+
+	if hub.relayUsage != nil {
+		roomStats.Relay = hub.relayUsage(roomId)
 	}
 
 	return roomStats
 }
+
+// roomStats turns a stored room into its stats. Needs Mu.
+func (hub *Hub) roomStats(room store.Room) types.RoomStats {
+	roomStats := types.RoomStats{
+		RoomID:  room.ID,
+		Clients: room.Clients,
+	}
+	hub.broadcastStats(&roomStats, &room.Options, room.Connected)
+	return roomStats
+}
@@ -0,0 +1,110 @@
+package ice
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"signaling-server-webrtc/pkg/types"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+Config is the list of ICE servers handed to clients (GET /api/ice-servers and the
+create/join responses).
+
+TURN credentials are never stored: they are minted per request with the coturn
+"REST API" shared secret scheme, so they expire by themselves:
+
+	username   = "<unix expiry>:<user id>"
+	credential = base64(HMAC-SHA1(sharedSecret, username))
+
+coturn is configured with `use-auth-secret` + `static-auth-secret=<sharedSecret>`.
+*/
+type Config struct {
+	STUNURLs     []string
+	TURNURLs     []string
+	SharedSecret string
+	TTL          time.Duration
+
+	defaultSTUN bool // STUNURLs is the public fallback, not something the operator configured
+}
+
+// ConfigFromEnv reads ICE_STUN_URLS, ICE_TURN_URLS (comma separated), TURN_SHARED_SECRET and TURN_CREDENTIAL_TTL
+func ConfigFromEnv() *Config {
+	stun := utils.GetEnv("ICE_STUN_URLS")
+	defaultSTUN := stun == ""
+	if defaultSTUN {
+		stun = "stun:stun.l.google.com:19302" // what the browser client used to hard code
+	}
+	return &Config{
+		STUNURLs:     splitList(stun),
+		TURNURLs:     splitList(utils.GetEnv("ICE_TURN_URLS")),
+		SharedSecret: utils.GetEnv("TURN_SHARED_SECRET"),
+		TTL:          utils.GetEnvDuration("TURN_CREDENTIAL_TTL", time.Hour),
+		defaultSTUN:  defaultSTUN,
+	}
+}
+
+// AdvertiseSTUN puts an embedded STUN server first in the list. The public fallback
+// is dropped then, an install with its own STUN must not depend on internet egress.
+func (c *Config) AdvertiseSTUN(url string) {
+	if c.defaultSTUN {
+		c.STUNURLs = nil
+		c.defaultSTUN = false
+	}
+	c.STUNURLs = append([]string{url}, c.STUNURLs...)
+}
+
+func splitList(list string) []string {
+	var out []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// AdvertiseTURN adds the embedded TURN server, it shares SharedSecret with any external one
+func (c *Config) AdvertiseTURN(urls ...string) {
+	c.TURNURLs = append(urls, c.TURNURLs...)
+}
+
+// Servers returns the RTCIceServer list for one client, TURN entries get fresh credentials.
+// With a roomId the credentials are bound to the room ("<roomId>:<clientId>"), which the
+// embedded TURN server requires.
+func (c *Config) Servers(roomId, clientId string) []types.ICEServer {
+	servers := c.STUNServers()
+	if len(c.TURNURLs) > 0 && c.SharedSecret != "" {
+		userId := clientId
+		if roomId != "" {
+			userId = roomId + ":" + clientId
+		}
+		username, credential := TURNCredentials(c.SharedSecret, userId, c.TTL)
+		servers = append(servers, types.ICEServer{
+			URLs:       c.TURNURLs,
+			Username:   username,
+			Credential: credential,
+		})
+	}
+	return servers
+}
+
+// STUNServers is the list without TURN, for callers that are not part of a room
+func (c *Config) STUNServers() []types.ICEServer {
+	if len(c.STUNURLs) == 0 {
+		return nil
+	}
+	return []types.ICEServer{{URLs: c.STUNURLs}}
+}
+
+func TURNCredentials(secret, userId string, ttl time.Duration) (username, credential string) {
+	username = fmt.Sprintf("%d:%s", time.Now().Add(ttl).Unix(), userId)
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,168 @@
+package ice
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/pion/stun/v3"
+
+	"signaling-server-webrtc/utils"
+)
+
+const stunHeaderSize = 20
+
+// a TCP client gets this long for each request (and to send the next one), idle ones are dropped
+const stunTCPTimeout = 5 * time.Second
+
+// STUN_TCP_MAX_CONNS caps the open TCP connections, more are closed right away (0 = no limit)
+var stunMaxTCPConns = utils.GetEnvInt("STUN_TCP_MAX_CONNS", 1000)
+
+/*
+STUNServer answers STUN Binding requests (RFC 5389) on UDP and TCP so an on-prem
+install does not depend on a public STUN server. It only tells the client its
+public address (XOR-MAPPED-ADDRESS), there is no relaying here.
+*/
+type STUNServer struct {
+	udp      net.PacketConn
+	tcp      net.Listener
+	tcpConns chan struct{} // one token per open TCP connection, nil without a cap
+}
+
+// ListenSTUN opens both the UDP and the TCP listener on addr (e.g. ":3478")
+func ListenSTUN(addr string) (*STUNServer, error) {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+	s := &STUNServer{udp: udp, tcp: tcp}
+	if stunMaxTCPConns > 0 {
+		s.tcpConns = make(chan struct{}, stunMaxTCPConns)
+	}
+	return s, nil
+}
+
+func (s *STUNServer) Serve() {
+	log.Printf("STUN server started, UDP+TCP: %v\n", s.udp.LocalAddr())
+	go s.serveUDP()
+	go s.serveTCP()
+}
+
+func (s *STUNServer) Close() error {
+	return errors.Join(s.udp.Close(), s.tcp.Close())
+}
+
+func (s *STUNServer) serveUDP() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("STUN UDP read error:", err)
+			}
+			return
+		}
+
+		resp, ok := bindingResponse(buf[:n], from)
+		if !ok {
+			continue // not a binding request, ignore like any other junk on the port
+		}
+		s.udp.WriteTo(resp, from)
+	}
+}
+
+func (s *STUNServer) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("STUN TCP accept error:", err)
+			}
+			return
+		}
+		if s.tcpConns != nil {
+			select {
+			case s.tcpConns <- struct{}{}:
+			default:
+				conn.Close() // full, the client still has UDP
+				continue
+			}
+		}
+		go s.serveTCPConn(conn)
+	}
+}
+
+// on TCP the messages are back to back, the header carries the length of the attributes
+func (s *STUNServer) serveTCPConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		if s.tcpConns != nil {
+			<-s.tcpConns
+		}
+	}()
+
+	header := make([]byte, stunHeaderSize)
+	for {
+		// re-armed per request: a slow or silent client does not hold the connection forever
+		conn.SetDeadline(time.Now().Add(stunTCPTimeout))
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint16(header[2:4])
+		raw := make([]byte, stunHeaderSize+int(length))
+		copy(raw, header)
+		if _, err := io.ReadFull(conn, raw[stunHeaderSize:]); err != nil {
+			return
+		}
+
+		resp, ok := bindingResponse(raw, conn.RemoteAddr())
+		if !ok {
+			return // not speaking STUN, drop the connection
+		}
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// bindingResponse builds the success response carrying the address the request came from
+func bindingResponse(raw []byte, from net.Addr) ([]byte, bool) {
+	if !stun.IsMessage(raw) {
+		return nil, false
+	}
+	req := &stun.Message{Raw: append([]byte(nil), raw...)}
+	if err := req.Decode(); err != nil || req.Type != stun.BindingRequest {
+		return nil, false
+	}
+
+	var ip net.IP
+	var port int
+	switch a := from.(type) {
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	default:
+		return nil, false
+	}
+
+	resp, err := stun.Build(
+		stun.NewTransactionIDSetter(req.TransactionID),
+		stun.BindingSuccess,
+		&stun.XORMappedAddress{IP: ip, Port: port},
+		stun.NewSoftware("signaling-server-webrtc"),
+		stun.Fingerprint,
+	)
+	if err != nil {
+		return nil, false
+	}
+	return resp.Raw, true
+}
@@ -0,0 +1,366 @@
+package ice
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/turn/v4"
+
+	"signaling-server-webrtc/pkg/types"
+	"signaling-server-webrtc/utils"
+)
+
+// Reservations is what the TURN server needs from the hub: is this client (still) part of the room
+type Reservations interface {
+	IsReserved(roomId, clientId string) bool
+}
+
+/*
+TURNConfig are the limits of the embedded relay:
+
+  - MaxBytesPerSec: per allocation, in each direction. Packets above it are dropped (0 = no limit)
+  - MaxLifetime: an allocation is ended after this long, refreshes or not (0 = no limit)
+  - MaxRoomAllocations: concurrent allocations per room (0 = no limit)
+*/
+type TURNConfig struct {
+	Addr               string // listen address for UDP and TCP, e.g. ":3479"
+	PublicIP           net.IP // address put in the relay candidates
+	Realm              string
+	MaxBytesPerSec     int
+	MaxLifetime        time.Duration
+	MaxRoomAllocations int
+}
+
+/*
+TURNServer is a pion/turn relay that only accepts credentials minted by Config.Servers
+for a room/client reservation that still exists in the hub:
+
+	username = "<unix expiry>:<roomId>:<clientId>"
+
+Usage per room is kept in memory and reported through RoomUsage.
+*/
+type TURNServer struct {
+	server *turn.Server
+	cfg    TURNConfig
+	secret string
+	hub    Reservations
+
+	mu     sync.Mutex
+	relays map[string]*relayConn // relay address -> conn, until the allocation is created it has no room
+	rooms  map[string]*roomRelay // roomId -> allocations still open + bytes of all of them
+}
+
+type roomRelay struct {
+	allocations int // guarded by TURNServer.mu
+	sent        atomic.Uint64
+	received    atomic.Uint64
+}
+
+func ListenTURN(cfg TURNConfig, secret string, hub Reservations) (*TURNServer, error) {
+	if cfg.PublicIP == nil {
+		return nil, fmt.Errorf("TURN needs a public IP for relay candidates")
+	}
+	if cfg.Realm == "" {
+		cfg.Realm = "signaling-server"
+	}
+
+	s := &TURNServer{
+		cfg:    cfg,
+		secret: secret,
+		hub:    hub,
+		relays: make(map[string]*relayConn),
+		rooms:  make(map[string]*roomRelay),
+	}
+
+	udp, err := net.ListenPacket("udp4", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp4", cfg.Addr)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+
+	s.server, err = turn.NewServer(turn.ServerConfig{
+		Realm:        cfg.Realm,
+		AuthHandler:  s.authenticate,
+		QuotaHandler: s.checkRoomQuota,
+		EventHandler: turn.EventHandler{
+			OnAllocationCreated: s.onAllocationCreated,
+			OnAllocationDeleted: s.onAllocationDeleted,
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn:            udp,
+			RelayAddressGenerator: &quotaRelayGenerator{s: s},
+		}},
+		ListenerConfigs: []turn.ListenerConfig{{
+			Listener:              tcp,
+			RelayAddressGenerator: &quotaRelayGenerator{s: s},
+		}},
+	})
+	if err != nil {
+		udp.Close()
+		tcp.Close()
+		return nil, err
+	}
+
+	log.Printf("TURN server started, UDP+TCP: %v, relay IP: %v\n", udp.LocalAddr(), cfg.PublicIP)
+	return s, nil
+}
+
+func (s *TURNServer) Close() error {
+	return s.server.Close()
+}
+
+// splitUsername parses "<expiry>:<roomId>:<clientId>"
+func splitUsername(username string) (expiry int64, roomId, clientId string, ok bool) {
+	parts := strings.SplitN(username, ":", 3)
+	if len(parts) != 3 {
+		return 0, "", "", false
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", "", false
+	}
+	return expiry, parts[1], parts[2], true
+}
+
+func (s *TURNServer) authenticate(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+	expiry, roomId, clientId, ok := splitUsername(username)
+	if !ok || time.Now().Unix() > expiry {
+		return nil, false
+	}
+	if !s.hub.IsReserved(roomId, clientId) {
+		log.Printf("[Room:%s] [Client:%s] TURN auth refused, not part of the room\n", roomId, clientId)
+		return nil, false
+	}
+
+	mac := hmac.New(sha1.New, []byte(s.secret))
+	mac.Write([]byte(username))
+	password := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return turn.GenerateAuthKey(username, realm, password), true
+}
+
+func (s *TURNServer) checkRoomQuota(username, realm string, srcAddr net.Addr) bool {
+	if s.cfg.MaxRoomAllocations <= 0 {
+		return true
+	}
+	_, roomId, _, _ := splitUsername(username)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if usage := s.rooms[roomId]; usage != nil && usage.allocations >= s.cfg.MaxRoomAllocations {
+		log.Printf("[Room:%s] TURN allocation refused, room quota of %d reached\n", roomId, s.cfg.MaxRoomAllocations)
+		return false
+	}
+	return true
+}
+
+// the relay conn was made by the generator before pion knew the user, it gets its room here
+func (s *TURNServer) onAllocationCreated(srcAddr, dstAddr net.Addr, protocol, username, realm string, relayAddr net.Addr, requestedPort int) {
+	_, roomId, _, _ := splitUsername(username)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := s.rooms[roomId]
+	if usage == nil {
+		usage = &roomRelay{}
+		s.rooms[roomId] = usage
+	}
+	usage.allocations++
+
+	if rc := s.relays[relayAddr.String()]; rc != nil {
+		rc.setUsage(usage)
+	}
+}
+
+func (s *TURNServer) onAllocationDeleted(srcAddr, dstAddr net.Addr, protocol, username, realm string) {
+	_, roomId, clientId, _ := splitUsername(username)
+	reserved := s.hub.IsReserved(roomId, clientId) // before s.mu, the hub may be asking RoomUsage right now
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := s.rooms[roomId]
+	if usage == nil {
+		return
+	}
+	usage.allocations--
+	if usage.allocations <= 0 && !reserved {
+		delete(s.rooms, roomId) // room is gone, nobody will ask for its stats anymore
+	}
+}
+
+// RoomUsage is plugged into the hub room stats
+func (s *TURNServer) RoomUsage(roomId string) *types.RelayUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := s.rooms[roomId]
+	if usage == nil {
+		return nil
+	}
+	return &types.RelayUsage{
+		Allocations:   usage.allocations,
+		BytesSent:     usage.sent.Load(),
+		BytesReceived: usage.received.Load(),
+	}
+}
+
+// quotaRelayGenerator allocates relay sockets like turn.RelayAddressGeneratorStatic and wraps them in a relayConn
+type quotaRelayGenerator struct {
+	turn.RelayAddressGeneratorStatic
+	s *TURNServer
+}
+
+func (g *quotaRelayGenerator) Validate() error {
+	g.RelayAddress = g.s.cfg.PublicIP
+	g.Address = "0.0.0.0"
+	return g.RelayAddressGeneratorStatic.Validate()
+}
+
+func (g *quotaRelayGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, relayAddr, err := g.RelayAddressGeneratorStatic.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rc := newRelayConn(conn, g.s.cfg.MaxBytesPerSec)
+	key := relayAddr.String()
+	g.s.mu.Lock()
+	g.s.relays[key] = rc
+	g.s.mu.Unlock()
+
+	rc.onClose = func() {
+		g.s.mu.Lock()
+		delete(g.s.relays, key)
+		g.s.mu.Unlock()
+	}
+	if g.s.cfg.MaxLifetime > 0 {
+		// pion deletes the allocation when a read on its relay socket fails, see relayConn.ReadFrom
+		time.AfterFunc(g.s.cfg.MaxLifetime, func() { rc.Close() })
+	}
+	return rc, relayAddr, nil
+}
+
+// relayMTU is the least burst of a bandwidth quota, a smaller one would drop every full size packet
+const relayMTU = 1500
+
+/*
+relayConn is the relay socket of one allocation. It drops packets above the
+bandwidth quota (like a congested link would) and counts the bytes into the
+usage of the room the allocation belongs to.
+*/
+type relayConn struct {
+	net.PacketConn
+	onClose func()
+
+	// one bucket per direction, a busy upload must not starve the download. nil without bandwidth quota.
+	sendLimiter, receiveLimiter *utils.RateLimiter
+
+	mu     sync.Mutex
+	usage  *roomRelay
+	closed bool
+}
+
+func newRelayConn(conn net.PacketConn, bytesPerSec int) *relayConn {
+	rc := &relayConn{PacketConn: conn}
+	if bytesPerSec > 0 {
+		burst := float64(max(bytesPerSec, relayMTU))
+		rc.sendLimiter = utils.NewRateLimiter(float64(bytesPerSec), burst)
+		rc.receiveLimiter = utils.NewRateLimiter(float64(bytesPerSec), burst)
+	}
+	return rc
+}
+
+func (rc *relayConn) setUsage(usage *roomRelay) {
+	rc.mu.Lock()
+	rc.usage = usage
+	rc.mu.Unlock()
+}
+
+// account returns false when the packet is over the quota and must be dropped
+func (rc *relayConn) account(n int, sent bool) bool {
+	limiter := rc.receiveLimiter
+	if sent {
+		limiter = rc.sendLimiter
+	}
+	if limiter != nil && !limiter.Allow(float64(n)) {
+		return false
+	}
+
+	rc.mu.Lock()
+	usage := rc.usage
+	rc.mu.Unlock()
+
+	if usage != nil {
+		if sent {
+			usage.sent.Add(uint64(n))
+		} else {
+			usage.received.Add(uint64(n))
+		}
+	}
+	return true
+}
+
+// ReadFrom gets what the remote peer sends towards our client. Once closed it fails,
+// which is how pion's allocation learns that it is over and deletes itself.
+func (rc *relayConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := rc.PacketConn.ReadFrom(p)
+		if rc.isClosed() {
+			return 0, nil, net.ErrClosed
+		}
+		if err != nil || rc.account(n, false) {
+			return n, addr, err
+		}
+	}
+}
+
+// WriteTo sends what our client relays to the remote peer
+func (rc *relayConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if rc.isClosed() {
+		return 0, net.ErrClosed
+	}
+	if !rc.account(len(p), true) {
+		return len(p), nil // dropped, UDP does not promise delivery anyway
+	}
+	return rc.PacketConn.WriteTo(p, addr)
+}
+
+func (rc *relayConn) Close() error {
+	rc.mu.Lock()
+	alreadyClosed := rc.closed
+	rc.closed = true
+	rc.mu.Unlock()
+
+	if alreadyClosed {
+		return nil
+	}
+	if rc.onClose != nil {
+		rc.onClose()
+	}
+	err := rc.PacketConn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (rc *relayConn) isClosed() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.closed
+}
@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"encoding/json"
+	"log"
+
+	"signaling-server-webrtc/pkg/signal"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+inspect looks at a message read from a client before it reaches the hub.
+It runs in the client's ReadPump goroutine so parsing never blocks Hub.Run.
+
+Returns the data to relay (possibly rewritten), nil data to drop the message
+silently, or an error that is sent back to the sender instead.
+Untyped (non JSON) messages are relayed as they are.
+*/
+func (h *Hub) inspect(c *Client, raw []byte) ([]byte, *signal.Error) {
+	msg, ok := signal.Parse(raw)
+	if !ok {
+		return raw, nil
+	}
+
+	switch msg.Type {
+	case "offer", "answer":
+		if _, err := h.sdpPolicy.ValidateDescription(msg.Type, msg.Data); err != nil {
+			utils.LogRoom(c.RoomID, c.ClientId, "🚫 Refused %s: %s", msg.Type, err)
+			return nil, err
+		}
+		return h.filterDescription(c, msg, raw), nil
+
+	case "candidate":
+		return h.filterCandidate(c, msg, raw), nil
+
+	case "relay":
+		if err := h.checkRelay(c, len(raw)); err != nil {
+			utils.LogRoom(c.RoomID, c.ClientId, "🚫 Refused relay: %s", err)
+			return nil, err
+		}
+	}
+
+	return raw, nil
+}
+
+// candidatePolicy returns the room's privacy policy or the hub default
+func (h *Hub) candidatePolicy(roomId string) signal.CandidatePolicy {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+
+	if opts := h.Options[roomId]; opts != nil && opts.CandidatePolicy != "" {
+		return signal.CandidatePolicy(opts.CandidatePolicy)
+	}
+	return h.candidates
+}
+
+// filterCandidate applies the room policy to a trickled candidate, nil means drop it
+func (h *Hub) filterCandidate(c *Client, msg signal.Message, raw []byte) []byte {
+	var cand map[string]any // RTCIceCandidateInit, other fields (sdpMid, ...) are kept as is
+	if err := json.Unmarshal(msg.Data, &cand); err != nil {
+		return raw
+	}
+	candidate, _ := cand["candidate"].(string)
+
+	policy := h.candidatePolicy(c.RoomID)
+	filtered, ok := policy.Filter(candidate)
+	if !ok {
+		utils.LogRoom(c.RoomID, c.ClientId, "🙈 Candidate dropped by %s policy", policy)
+		return nil
+	}
+	if filtered == candidate {
+		return raw
+	}
+
+	cand["candidate"] = filtered
+	msg.Data, _ = json.Marshal(cand)
+	out, _ := json.Marshal(msg)
+	return out
+}
+
+// filterDescription applies the room policy to the a=candidate lines of an offer/answer,
+// the message is only re-encoded when something changed
+func (h *Hub) filterDescription(c *Client, msg signal.Message, raw []byte) []byte {
+	var desc signal.SessionDescription
+	json.Unmarshal(msg.Data, &desc) // already validated
+
+	filtered, changed := h.candidatePolicy(c.RoomID).FilterSDP(desc.SDP)
+	if !changed {
+		return raw
+	}
+	utils.LogRoom(c.RoomID, c.ClientId, "🙈 Candidates filtered in %s", msg.Type)
+
+	desc.SDP = filtered
+	msg.Data, _ = json.Marshal(desc)
+	out, _ := json.Marshal(msg)
+	return out
+}
+
+// candidatePolicyFromEnv reads CANDIDATE_POLICY, an unknown value falls back to "all"
+func candidatePolicyFromEnv() signal.CandidatePolicy {
+	policy := signal.CandidatePolicy(utils.GetEnv("CANDIDATE_POLICY"))
+	if !policy.Valid() {
+		log.Printf("[WARN] unknown CANDIDATE_POLICY %q, using all", policy)
+		return signal.CandidatesAll
+	}
+	return policy
+}
+
+// sendError tells the client why its message was not relayed. It goes through the hub like
+// every other write to c.Send, so it cannot race with the hub closing the channel.
+func (c *Client) sendError(hub *Hub, err *signal.Error, forType string) {
+	hub.Broadcast <- MessageEnvelope{
+		Sender: c,
+		RoomID: c.RoomID,
+		Data:   signal.ErrorMessage(err, forType),
+		Direct: true,
+	}
+}
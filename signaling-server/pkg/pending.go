@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"time"
+
+	"signaling-server-webrtc/utils"
+)
+
+/*
+pendingQueue holds messages for a client that has a reserved slot in the room
+(placeholder, nil *Client) but no WebSocket yet. The offer and the first ICE
+candidates are usually sent before the answerer's socket is registered, without
+this they would be lost.
+
+	h.pending["roomId"]["ClientId"] -> [msg1, msg2, ...] flushed on Register
+
+Bounded by maxPendingMessages (oldest dropped first) and maxPendingAge.
+Only touched from the Run goroutine.
+*/
+type pendingQueue struct {
+	items []pendingMessage
+}
+
+type pendingMessage struct {
+	data     []byte
+	queuedAt time.Time
+}
+
+var (
+	maxPendingMessages = max(utils.GetEnvInt("PENDING_MAX_MESSAGES", 64), 0) // 0 holds nothing, below would break push
+	maxPendingAge      = utils.GetEnvDuration("PENDING_MAX_AGE", 30*time.Second)
+)
+
+func pendingTimerKey(roomId string) string {
+	return "pending:" + roomId
+}
+
+func (q *pendingQueue) push(data []byte) {
+	q.items = append(q.items, pendingMessage{data: data, queuedAt: time.Now()})
+	if len(q.items) > maxPendingMessages {
+		q.items = q.items[len(q.items)-maxPendingMessages:]
+	}
+}
+
+// dropExpired removes messages older than maxPendingAge, items are in queue order so the old ones are in front
+func (q *pendingQueue) dropExpired() {
+	cutoff := time.Now().Add(-maxPendingAge)
+	i := 0
+	for i < len(q.items) && q.items[i].queuedAt.Before(cutoff) {
+		i++
+	}
+	q.items = q.items[i:]
+}
+
+// queuePending keeps a relayed message for a reserved client until it connects
+func (h *Hub) queuePending(roomId, clientId string, data []byte) {
+	if h.pending[roomId] == nil {
+		h.pending[roomId] = make(map[string]*pendingQueue)
+	}
+	q := h.pending[roomId][clientId]
+	if q == nil {
+		q = &pendingQueue{}
+		h.pending[roomId][clientId] = q
+	}
+	q.push(data)
+
+	// expired messages are pruned even if the client never shows up
+	if !h.timers.Pending(pendingTimerKey(roomId)) {
+		h.timers.Schedule(pendingTimerKey(roomId), maxPendingAge, func() { h.prunePending(roomId) })
+	}
+}
+
+// flushPending delivers everything queued for c, called right after it is registered
+func (h *Hub) flushPending(c *Client) {
+	q := h.pending[c.RoomID][c.ClientId]
+	if q == nil {
+		return
+	}
+	delete(h.pending[c.RoomID], c.ClientId)
+
+	q.dropExpired()
+	for _, m := range q.items {
+		select {
+		case c.Send <- m.data:
+		default:
+			utils.LogRoom(c.RoomID, c.ClientId, "Cannot flush pending message - channel full")
+		}
+	}
+	if len(q.items) > 0 {
+		utils.LogRoom(c.RoomID, c.ClientId, "📬 Delivered %d pending messages", len(q.items))
+	}
+}
+
+func (h *Hub) prunePending(roomId string) {
+	for clientId, q := range h.pending[roomId] {
+		q.dropExpired()
+		if len(q.items) == 0 {
+			delete(h.pending[roomId], clientId)
+		}
+	}
+	if len(h.pending[roomId]) == 0 {
+		delete(h.pending, roomId)
+		return
+	}
+	h.timers.Schedule(pendingTimerKey(roomId), maxPendingAge, func() { h.prunePending(roomId) })
+}
+
+func (h *Hub) dropPending(roomId string) {
+	delete(h.pending, roomId)
+	h.timers.Cancel(pendingTimerKey(roomId))
+}
@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"signaling-server-webrtc/utils"
+)
+
+/*
+A single instance with the memory store loses every room when the process dies, and
+clients reconnecting with their ids get "Unauthorized". With SNAPSHOT_FILE the hub
+writes its Snapshot there every SNAPSHOT_INTERVAL and on shutdown, and the next start
+loads it: rooms, slots, options (presenter, offerer, policies) and held messages come
+back under the same ids. Clients that were connected keep their slot for
+SNAPSHOT_RECONNECT_GRACE, mesh roles are handed out again once both are back.
+*/
+var snapshotGrace = utils.GetEnvDuration("SNAPSHOT_RECONNECT_GRACE", time.Minute)
+
+// Snapshot asks Run for the current rooms, only while Run is running
+func (h *Hub) Snapshot() Snapshot {
+	reply := make(chan Snapshot)
+	h.snapshots <- reply
+	return <-reply
+}
+
+// SaveSnapshot writes the current rooms to path, atomically: a crash mid-write leaves the previous file
+func (h *Hub) SaveSnapshot(path string) error {
+	raw, err := json.Marshal(h.Snapshot())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // gone after the rename anyway
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot restores the rooms saved at path, before Run is started. A missing file is a fresh start.
+func (h *Hub) LoadSnapshot(path string) (rooms int, err error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, err
+	}
+	if reservationTTL > 0 && time.Since(s.Taken) > reservationTTL {
+		return 0, nil // every room in it would have expired by now
+	}
+	h.restore(s, nil, snapshotGrace)
+	return len(s.Rooms), nil
+}
+
+// SaveSnapshots writes a snapshot every interval until stop is closed
+func (h *Hub) SaveSnapshots(path string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.SaveSnapshot(path); err != nil {
+				utils.LogRoom("Nil", "Nil", "snapshot: save failed: %s", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"time"
+
+	"signaling-server-webrtc/utils"
+)
+
+/*
+A client whose socket drops keeps its slot for RECONNECT_GRACE (default 10s, 0 = off):
+the slot goes back to a placeholder, messages for it are queued like before its first
+connect, and a new WebSocket with the same roomId/clientId takes it over. After the
+grace the reservation is dropped as if the client had left.
+*/
+var reconnectGrace = utils.GetEnvDuration("RECONNECT_GRACE", 10*time.Second)
+
+func graceTimerKey(roomId, clientId string) string {
+	return "grace:" + roomId + ":" + clientId
+}
+
+// holdSlot keeps the placeholder of a disconnected client until the grace is over
+func (h *Hub) holdSlot(roomId, clientId string, grace time.Duration) {
+	h.timers.Schedule(graceTimerKey(roomId, clientId), grace, func() { h.expireSlot(roomId, clientId) })
+}
+
+func (h *Hub) expireSlot(roomId, clientId string) {
+	h.Mu.RLock()
+	c, ok := h.Rooms[roomId][clientId]
+	elsewhere := h.remote[roomId][clientId] != ""
+	h.Mu.RUnlock()
+
+	if !ok || c != nil {
+		return // room closed, or the client is back
+	}
+	if elsewhere {
+		return // back on another node
+	}
+	utils.LogRoom(roomId, clientId, "⌛ did not reconnect, slot released")
+	h.deleteSlot(roomId, clientId)
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"sync"
+
+	"signaling-server-webrtc/pkg/signal"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+"relay" messages carry application payloads (chat, ...) through the hub when the peers
+cannot reach each other directly (ICE failed, strict firewall):
+
+	{"type":"relay","data":{"text":"hello"}}
+
+They are relayed like any other message but have their own limits, far below what the
+signaling needs, so the server does not turn into a media relay:
+
+  - RELAY_MAX_BYTES: size of the whole message (default 16 KiB)
+  - RELAY_RATE / RELAY_BURST: messages per second per client, token bucket (default 5/s, burst 20)
+
+The bucket belongs to the slot, not to the socket: reconnecting does not refill it.
+*/
+
+const (
+	ErrRelayTooLarge    = "relay-too-large"
+	ErrRelayRateLimited = "relay-rate-limited"
+)
+
+var (
+	relayMaxBytes = utils.GetEnvInt("RELAY_MAX_BYTES", 16*1024)
+	relayRate     = utils.GetEnvInt("RELAY_RATE", 5)
+	relayBurst    = utils.GetEnvInt("RELAY_BURST", 20)
+)
+
+// relayLimits are the token buckets of every slot, roomId -> ClientId -> bucket
+type relayLimits struct {
+	mu    sync.Mutex
+	rooms map[string]map[string]*utils.RateLimiter
+}
+
+func (l *relayLimits) get(roomId, clientId string) *utils.RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rooms == nil {
+		l.rooms = make(map[string]map[string]*utils.RateLimiter)
+	}
+	if l.rooms[roomId] == nil {
+		l.rooms[roomId] = make(map[string]*utils.RateLimiter)
+	}
+	limiter := l.rooms[roomId][clientId]
+	if limiter == nil {
+		limiter = utils.NewRateLimiter(float64(relayRate), float64(relayBurst))
+		l.rooms[roomId][clientId] = limiter
+	}
+	return limiter
+}
+
+// forget drops the bucket of a released slot, or of the whole room with clientId ""
+func (l *relayLimits) forget(roomId, clientId string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if clientId == "" {
+		delete(l.rooms, roomId)
+		return
+	}
+	delete(l.rooms[roomId], clientId)
+	if len(l.rooms[roomId]) == 0 {
+		delete(l.rooms, roomId)
+	}
+}
+
+// checkRelay applies the relay limits to one message of c, runs in its ReadPump
+func (h *Hub) checkRelay(c *Client, size int) *signal.Error {
+	if size > relayMaxBytes {
+		return signal.NewError(ErrRelayTooLarge, "relay message is %d bytes, limit is %d", size, relayMaxBytes)
+	}
+	if !h.relayLimits.get(c.RoomID, c.ClientId).Allow(1) {
+		return signal.NewError(ErrRelayRateLimited, "more than %d relay messages per second", relayRate)
+	}
+	return nil
+}
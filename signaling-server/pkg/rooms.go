@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"errors"
+	"time"
+
+	"signaling-server-webrtc/pkg/bus"
+	"signaling-server-webrtc/pkg/store"
+	"signaling-server-webrtc/pkg/types"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+Reservations live in the RoomStore, Hub.Rooms is the local view of this node: the
+connected clients plus placeholders for the slots it knows about. REST handlers go
+through the methods below instead of touching Rooms, so the store and the local view
+stay in step. A room unknown locally (created on another instance, or before a
+restart) is loaded from the store when a client of it shows up here.
+
+Rooms are forgotten ROOM_RESERVATION_TTL (default 24h) after their last connect or
+disconnect, so reservations of a crashed instance do not pile up in a shared store.
+*/
+var reservationTTL = utils.GetEnvDuration("ROOM_RESERVATION_TTL", 24*time.Hour)
+
+// CreateRoom stores a new room with its first slots, store.ErrRoomExists if the id is taken
+func (h *Hub) CreateRoom(roomId string, opts types.RoomOptions, clientIds ...string) error {
+	err := h.store.Create(store.Room{ID: roomId, Options: opts, Clients: clientIds, Node: h.node, CreatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	h.touchRoom(roomId)
+	topology := opts.Topology
+	if topology == "" {
+		topology = TopologyMesh
+	}
+	h.emit(types.EventRoomCreated, roomId, "", map[string]any{"topology": topology, "slots": len(clientIds)})
+	for _, clientId := range clientIds {
+		h.emit(types.EventClientReserved, roomId, clientId, nil)
+	}
+
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+
+	h.Rooms[roomId] = make(map[string]*Client)
+	h.Options[roomId] = &opts
+	for _, clientId := range clientIds {
+		h.Rooms[roomId][clientId] = nil // placeholder until the WebSocket connects
+	}
+	return nil
+}
+
+// SetRoomOwnership restricts new room ids to the ones routed to this node, see pkg/affinity
+func (h *Hub) SetRoomOwnership(owns func(roomId string) bool) {
+	h.ownsRoomID = owns
+}
+
+// OwnsRoomID tells if a room created here with this id would be routed back to this node
+func (h *Hub) OwnsRoomID(roomId string) bool {
+	return h.ownsRoomID == nil || h.ownsRoomID(roomId)
+}
+
+// ReserveSlot adds a slot to an existing room, store.ErrRoomNotFound otherwise
+func (h *Hub) ReserveSlot(roomId, clientId string) error {
+	if err := h.store.Reserve(roomId, clientId); err != nil {
+		return err
+	}
+	h.emit(types.EventClientReserved, roomId, clientId, nil)
+	h.publish(bus.Message{Kind: bus.KindReserved, RoomID: roomId, From: clientId}) // the nodes relaying for the room hold its messages
+
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+
+	if room := h.Rooms[roomId]; room != nil {
+		if _, ok := room[clientId]; !ok {
+			room[clientId] = nil
+		}
+	}
+	return nil
+}
+
+// ReleaseSlot gives back a slot nobody connected to, e.g. for a bot that failed to start
+func (h *Hub) ReleaseSlot(roomId, clientId string) {
+	if h.GetClientFromRoom(roomId, clientId) != nil {
+		return // connected, it leaves through Unregister
+	}
+	if err := h.store.Release(roomId, clientId); err != nil {
+		utils.LogRoom(roomId, clientId, "room store: release failed: %s", err)
+	}
+
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+
+	if c := h.Rooms[roomId][clientId]; c != nil {
+		return // connected meanwhile, the store gave it its slot back with Connect
+	}
+	delete(h.Rooms[roomId], clientId)
+	h.relayLimits.forget(roomId, clientId)
+	h.emit(types.EventClientReleased, roomId, clientId, nil)
+	if len(h.Rooms[roomId]) == 0 {
+		delete(h.Rooms, roomId)
+		delete(h.Options, roomId)
+		h.emit(types.EventRoomDeleted, roomId, "", map[string]any{"reason": "empty"})
+	}
+}
+
+// DeleteRoom drops a room nobody is connected to yet
+func (h *Hub) DeleteRoom(roomId string) {
+	if err := h.store.Delete(roomId); err != nil {
+		utils.LogRoom(roomId, "Nil", "room store: delete failed: %s", err)
+	}
+
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+
+	delete(h.Rooms, roomId)
+	delete(h.Options, roomId)
+	delete(h.remote, roomId)
+	h.relayLimits.forget(roomId, "")
+	h.emit(types.EventRoomDeleted, roomId, "", map[string]any{"reason": "unused"})
+}
+
+// LookupRoom returns the stored room with all its slots, wherever they are connected
+func (h *Hub) LookupRoom(roomId string) (store.Room, error) {
+	return h.store.Lookup(roomId)
+}
+
+// hydrate loads a room this node does not know yet from the store. Takes Mu, only after the lookup.
+func (h *Hub) hydrate(roomId string) {
+	if h.knowsRoom(roomId) {
+		return
+	}
+	room, err := h.store.Lookup(roomId)
+	if err != nil {
+		if !errors.Is(err, store.ErrRoomNotFound) {
+			utils.LogRoom(roomId, "Nil", "room store: lookup failed: %s", err)
+		}
+		return
+	}
+
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+
+	if h.Rooms[roomId] != nil {
+		return // loaded meanwhile
+	}
+	h.Rooms[roomId] = make(map[string]*Client)
+	h.Options[roomId] = &room.Options
+	h.cacheRemote(room)
+	utils.LogRoom(roomId, "Nil", "room loaded from store, %d slots", len(room.Clients))
+}
+
+// touchRoom restarts the room's expiry in the store
+func (h *Hub) touchRoom(roomId string) {
+	err := h.store.Expire(roomId, reservationTTL)
+	if err != nil && !errors.Is(err, store.ErrRoomNotFound) {
+		utils.LogRoom(roomId, "Nil", "room store: expire failed: %s", err)
+	}
+}
@@ -0,0 +1,70 @@
+package sfu
+
+import "sync"
+
+/*
+outbox sends a peer's signaling messages in order from its own goroutine. The room
+queues them while holding mu; send goes through the hub and may block until Run
+gets to it, which must not happen with mu held.
+*/
+type outbox struct {
+	send func([]byte)
+
+	mu     sync.Mutex
+	queue  [][]byte
+	closed bool
+	wake   chan struct{}
+}
+
+func newOutbox(send func([]byte)) *outbox {
+	o := &outbox{send: send, wake: make(chan struct{}, 1)}
+	go o.run()
+	return o
+}
+
+func (o *outbox) push(msg []byte) {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return
+	}
+	o.queue = append(o.queue, msg)
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default: // run has a wake up pending already
+	}
+}
+
+// close drops what was not sent yet, the client left. nil is fine, WHIP/WHEP peers have no outbox.
+func (o *outbox) close() {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.closed {
+		o.closed = true
+		o.queue = nil
+		close(o.wake)
+	}
+}
+
+func (o *outbox) run() {
+	for range o.wake {
+		for {
+			o.mu.Lock()
+			msgs := o.queue
+			o.queue = nil
+			o.mu.Unlock()
+			if len(msgs) == 0 {
+				break
+			}
+			for _, msg := range msgs {
+				o.send(msg)
+			}
+		}
+	}
+}
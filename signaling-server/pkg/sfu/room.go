@@ -0,0 +1,424 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+const keyframeInterval = 3 * time.Second
+
+/*
+room holds the PeerConnections of one SFU room and the tracks forwarded between them.
+
+	tracks["<publisher ClientId>:<track id>"] -> forwarded to every peer except the publisher
+	program[kind]                            -> fed by one WHIP publisher, played by WHEP viewers
+
+Everything that changes peers, tracks or negotiation state holds mu.
+*/
+type room struct {
+	id string
+
+	mu           sync.Mutex
+	peers        map[string]*peer
+	tracks       map[string]*forwardedTrack
+	program      map[webrtc.RTPCodecType]*webrtc.TrackLocalStaticRTP
+	programOwner map[webrtc.RTPCodecType]string
+}
+
+type peerKind int
+
+const (
+	peerWebSocket peerKind = iota // negotiates over the signaling WebSocket, renegotiates when tracks change
+	peerWHIP                      // publish only, one HTTP offer/answer
+	peerWHEP                      // play only, one HTTP offer/answer
+)
+
+type peer struct {
+	id   string
+	kind peerKind
+	pc   *webrtc.PeerConnection
+	dc   *webrtc.DataChannel
+	out  *outbox // to the client's WebSocket, nil for WHIP/WHEP
+
+	viewer     bool // broadcast viewer: receives the room, its tracks and data channel messages are not forwarded
+	needsOffer bool // tracks changed (or first connect) and the client has not seen an offer for it yet
+}
+
+type forwardedTrack struct {
+	owner string
+	local *webrtc.TrackLocalStaticRTP
+}
+
+func newRoom(id string, program map[webrtc.RTPCodecType]*webrtc.TrackLocalStaticRTP) *room {
+	return &room{
+		id:           id,
+		peers:        make(map[string]*peer),
+		tracks:       make(map[string]*forwardedTrack),
+		program:      program,
+		programOwner: make(map[webrtc.RTPCodecType]string),
+	}
+}
+
+func (r *room) peer(clientId string) *peer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.peers[clientId]
+}
+
+// setupPeer prepares a fresh PeerConnection: one audio + one video slot for what the client
+// publishes (not for viewers), a "chat" data channel and the callbacks that feed the room
+func (r *room) setupPeer(p *peer) error {
+	publishKinds := []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo}
+	if p.viewer {
+		publishKinds = nil
+	}
+	for _, kind := range publishKinds {
+		_, err := p.pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	dc, err := p.pc.CreateDataChannel("chat", nil)
+	if err != nil {
+		return err
+	}
+	p.dc = dc
+	if !p.viewer {
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) { r.forwardData(p.id, msg) })
+	}
+
+	p.pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c != nil {
+			p.out.push(encode("candidate", c.ToJSON()))
+		}
+	})
+	p.pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if p.viewer {
+			return // a viewer offering media is answered, but nobody gets it
+		}
+		r.forwardTrack(p, remote)
+	})
+	p.pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("[Room:%s] [Client:%s] SFU connection %s\n", r.id, p.id, state)
+	})
+
+	p.needsOffer = true
+	return nil
+}
+
+// addPeer adds p, or replaces the client's previous PeerConnection when it reconnected before leaving
+func (r *room) addPeer(p *peer) {
+	r.mu.Lock()
+	old := r.peers[p.id]
+	if old != nil {
+		r.dropTracks(p.id)
+	}
+	r.peers[p.id] = p
+	r.syncPeer(p)
+	if old != nil {
+		for _, other := range r.peers {
+			if other != p {
+				r.syncPeer(other) // the old tracks are gone
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	if old != nil {
+		old.out.close()
+		old.pc.Close() // outside mu like in removePeer
+	}
+}
+
+// removePeer drops the client and everything it published, empty is true when nobody is left
+func (r *room) removePeer(clientId string) (empty bool) {
+	r.mu.Lock()
+	p := r.peers[clientId]
+	delete(r.peers, clientId)
+	r.dropTracks(clientId)
+	for _, other := range r.peers {
+		r.syncPeer(other)
+	}
+	empty = len(r.peers) == 0
+	r.mu.Unlock()
+
+	// closed outside mu, the track loops of this peer take mu on their way out
+	if p != nil {
+		p.out.close()
+		p.pc.Close()
+	}
+	return empty
+}
+
+// dropTracks stops forwarding what the client published. Needs mu.
+func (r *room) dropTracks(clientId string) {
+	for id, t := range r.tracks {
+		if t.owner == clientId {
+			delete(r.tracks, id)
+		}
+	}
+}
+
+func (r *room) handleAnswer(p *peer, data json.RawMessage) error {
+	var answer webrtc.SessionDescription
+	if err := json.Unmarshal(data, &answer); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := p.pc.SetRemoteDescription(answer); err != nil {
+		return err
+	}
+	if p.needsOffer {
+		r.offer(p) // tracks changed while our previous offer was out
+	}
+	return nil
+}
+
+// handleOffer answers a client initiated negotiation. If our own offer is out at the same
+// time the server is the polite side: it rolls back and re-offers after answering.
+func (r *room) handleOffer(p *peer, data json.RawMessage) error {
+	var offer webrtc.SessionDescription
+	if err := json.Unmarshal(data, &offer); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p.pc.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		if err := p.pc.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+			return err
+		}
+		p.needsOffer = true
+	}
+
+	if err := p.pc.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+	answer, err := p.pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := p.pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	p.out.push(encode("answer", answer))
+
+	if p.needsOffer {
+		r.offer(p)
+	}
+	return nil
+}
+
+// syncPeer makes p send exactly the room's tracks (minus its own) and offers if that changed. Needs mu.
+func (r *room) syncPeer(p *peer) {
+	if p.kind != peerWebSocket {
+		return // WHIP/WHEP sessions have no channel to renegotiate on
+	}
+	if p.pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return
+	}
+
+	sending := make(map[string]bool)
+	for _, sender := range p.pc.GetSenders() {
+		track := sender.Track()
+		if track == nil {
+			continue
+		}
+		if _, ok := r.tracks[track.ID()]; ok {
+			sending[track.ID()] = true
+			continue
+		}
+		if err := p.pc.RemoveTrack(sender); err == nil {
+			p.needsOffer = true
+		}
+	}
+
+	for id, t := range r.tracks {
+		if t.owner == p.id || sending[id] {
+			continue
+		}
+		sender, err := p.pc.AddTrack(t.local)
+		if err != nil {
+			log.Printf("[Room:%s] [Client:%s] SFU cannot add track %s: %s\n", r.id, p.id, id, err)
+			continue
+		}
+		go drainRTCP(sender) // interceptors (NACK, reports) only work if RTCP is read
+		p.needsOffer = true
+	}
+
+	if p.needsOffer {
+		r.offer(p)
+	}
+}
+
+// offer sends a new offer, or leaves needsOffer set until the current negotiation is done. Needs mu.
+func (r *room) offer(p *peer) {
+	if p.pc.SignalingState() != webrtc.SignalingStateStable {
+		return
+	}
+
+	offer, err := p.pc.CreateOffer(nil)
+	if err == nil {
+		err = p.pc.SetLocalDescription(offer)
+	}
+	if err != nil {
+		log.Printf("[Room:%s] [Client:%s] SFU offer failed: %s\n", r.id, p.id, err)
+		return
+	}
+	p.needsOffer = false
+	p.out.push(encode("offer", offer))
+}
+
+func (r *room) addTrack(id, owner string, local *webrtc.TrackLocalStaticRTP) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tracks[id] = &forwardedTrack{owner: owner, local: local}
+	for _, p := range r.peers {
+		r.syncPeer(p)
+	}
+}
+
+// removeTrack drops local from the room, unless the id is forwarded by a newer PeerConnection of its owner by now
+func (r *room) removeTrack(id string, local *webrtc.TrackLocalStaticRTP) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t, ok := r.tracks[id]; !ok || t.local != local {
+		return
+	}
+	delete(r.tracks, id)
+	for _, p := range r.peers {
+		r.syncPeer(p)
+	}
+}
+
+// forwardTrack copies RTP from the publisher to the shared local track until the remote track ends
+func (r *room) forwardTrack(p *peer, remote *webrtc.TrackRemote) {
+	id := p.id + ":" + remote.ID()
+	// stream id = publisher's ClientId so subscribers can group tracks per participant
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, id, p.id)
+	if err != nil {
+		log.Printf("[Room:%s] [Client:%s] SFU cannot forward track: %s\n", r.id, p.id, err)
+		return
+	}
+
+	log.Printf("[Room:%s] [Client:%s] SFU forwarding %s track\n", r.id, p.id, remote.Kind())
+	r.addTrack(id, p.id, local)
+	defer r.removeTrack(id, local)
+
+	if remote.Kind() == webrtc.RTPCodecTypeVideo {
+		stop := make(chan struct{})
+		defer close(stop)
+		go requestKeyframes(p.pc, remote.SSRC(), stop)
+	}
+
+	var program *webrtc.TrackLocalStaticRTP
+	if p.kind == peerWHIP {
+		program = r.claimProgram(p.id, remote)
+		if program != nil {
+			defer r.releaseProgram(p.id, remote.Kind())
+		}
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := remote.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := local.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+		if program != nil {
+			program.Write(buf[:n])
+		}
+	}
+}
+
+// claimProgram makes the publisher's track the room program for its kind, if nobody else feeds it
+// and the codec is the one WHEP viewers negotiated
+func (r *room) claimProgram(owner string, remote *webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kind := remote.Kind()
+	program := r.program[kind]
+	if program == nil || r.programOwner[kind] != "" {
+		return nil
+	}
+	if !strings.EqualFold(program.Codec().MimeType, remote.Codec().MimeType) {
+		log.Printf("[Room:%s] [Client:%s] WHIP %s is %s, program plays %s, not forwarded to WHEP viewers\n",
+			r.id, owner, kind, remote.Codec().MimeType, program.Codec().MimeType)
+		return nil
+	}
+	r.programOwner[kind] = owner
+	return program
+}
+
+func (r *room) releaseProgram(owner string, kind webrtc.RTPCodecType) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.programOwner[kind] == owner {
+		delete(r.programOwner, kind)
+	}
+}
+
+// forwardData sends a data channel message to every other peer of the room
+func (r *room) forwardData(from string, msg webrtc.DataChannelMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, p := range r.peers {
+		if id == from || p.dc == nil || p.dc.ReadyState() != webrtc.DataChannelStateOpen {
+			continue
+		}
+		if msg.IsString {
+			p.dc.SendText(string(msg.Data))
+		} else {
+			p.dc.Send(msg.Data)
+		}
+	}
+}
+
+// requestKeyframes asks the publisher for a keyframe now and then, so new subscribers get a picture quickly
+func requestKeyframes(pc *webrtc.PeerConnection, ssrc webrtc.SSRC, stop <-chan struct{}) {
+	ticker := time.NewTicker(keyframeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)}}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func drainRTCP(sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
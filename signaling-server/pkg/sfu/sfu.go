@@ -0,0 +1,173 @@
+package sfu
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+
+	"signaling-server-webrtc/pkg/signal"
+)
+
+/*
+SFU is the server side media path for rooms with topology "sfu". Every client has
+exactly one PeerConnection with the server, the server forwards what it receives:
+
+	client A --RTP/datachannel--> [ PC A ] --+--> [ PC B ] --> client B
+	                                         +--> [ PC C ] --> client C
+
+Negotiation goes over the existing WebSocket with the usual offer/answer/candidate
+messages. The server sends a new offer whenever the set of forwarded tracks
+changes; a client may also send its own offer (e.g. after adding a track), the
+server then rolls back its pending offer and answers.
+*/
+type SFU struct {
+	api          *webrtc.API
+	config       webrtc.Configuration
+	programVideo webrtc.RTPCodecCapability
+
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+// Config is built in main from env (SFU_PUBLIC_IP, SFU_UDP_PORT_MIN/MAX, WHEP_VIDEO_CODEC) and the STUN servers
+type Config struct {
+	ICEServers []webrtc.ICEServer
+	PublicIP   string // put in host candidates when the server sits behind 1:1 NAT
+	UDPPortMin uint16
+	UDPPortMax uint16
+	WHEPVideo  string // "h264" (default, what OBS sends) or "vp8"
+}
+
+func New(cfg Config) (*SFU, error) {
+	settings := webrtc.SettingEngine{}
+	if cfg.PublicIP != "" {
+		settings.SetNAT1To1IPs([]string{cfg.PublicIP}, webrtc.ICECandidateTypeHost)
+	}
+	if cfg.UDPPortMin > 0 && cfg.UDPPortMax >= cfg.UDPPortMin {
+		if err := settings.SetEphemeralUDPPortRange(cfg.UDPPortMin, cfg.UDPPortMax); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.WHEPVideo == "" {
+		cfg.WHEPVideo = "h264"
+	}
+	programVideo, ok := programVideoCodecs[strings.ToLower(cfg.WHEPVideo)]
+	if !ok {
+		return nil, fmt.Errorf("unknown WHEP video codec %q", cfg.WHEPVideo)
+	}
+
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+	interceptors := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(media, interceptors); err != nil {
+		return nil, err
+	}
+
+	return &SFU{
+		api: webrtc.NewAPI(
+			webrtc.WithMediaEngine(media),
+			webrtc.WithInterceptorRegistry(interceptors),
+			webrtc.WithSettingEngine(settings),
+		),
+		config:       webrtc.Configuration{ICEServers: cfg.ICEServers},
+		programVideo: programVideo,
+		rooms:        make(map[string]*room),
+	}, nil
+}
+
+// Join creates the client's PeerConnection and sends it the first offer through send.
+// A viewer only receives, see peer.viewer.
+func (s *SFU) Join(roomId, clientId string, send func([]byte), viewer bool) error {
+	pc, err := s.api.NewPeerConnection(s.config)
+	if err != nil {
+		return err
+	}
+
+	r := s.room(roomId)
+	p := &peer{id: clientId, pc: pc, out: newOutbox(send), viewer: viewer}
+	if err := r.setupPeer(p); err != nil {
+		p.out.close()
+		pc.Close()
+		return err
+	}
+	r.addPeer(p)
+	return nil
+}
+
+// Leave closes the client's PeerConnection and stops forwarding its tracks to the others
+func (s *SFU) Leave(roomId, clientId string) {
+	s.mu.Lock()
+	r := s.rooms[roomId]
+	s.mu.Unlock()
+	if r == nil {
+		return
+	}
+
+	if empty := r.removePeer(clientId); empty {
+		s.mu.Lock()
+		if s.rooms[roomId] == r {
+			delete(s.rooms, roomId)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// HandleMessage takes the client's offer/answer/candidate. handled is false for any other type,
+// the hub relays those as usual.
+func (s *SFU) HandleMessage(roomId, clientId string, msg signal.Message) (handled bool, err error) {
+	switch msg.Type {
+	case "offer", "answer", "candidate":
+	default:
+		return false, nil
+	}
+
+	s.mu.Lock()
+	r := s.rooms[roomId]
+	s.mu.Unlock()
+	if r == nil {
+		return true, fmt.Errorf("not connected to the sfu")
+	}
+	p := r.peer(clientId)
+	if p == nil {
+		return true, fmt.Errorf("not connected to the sfu")
+	}
+
+	switch msg.Type {
+	case "answer":
+		return true, r.handleAnswer(p, msg.Data)
+	case "offer":
+		return true, r.handleOffer(p, msg.Data)
+	default:
+		var candidate webrtc.ICECandidateInit
+		if err := json.Unmarshal(msg.Data, &candidate); err != nil {
+			return true, err
+		}
+		return true, p.pc.AddICECandidate(candidate)
+	}
+}
+
+func (s *SFU) room(roomId string) *room {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.rooms[roomId]
+	if r == nil {
+		r = newRoom(roomId, s.newProgram(roomId))
+		s.rooms[roomId] = r
+	}
+	return r
+}
+
+// encode builds {"type":..,"data":..} like every other signaling message
+func encode(msgType string, data any) []byte {
+	raw, _ := json.Marshal(data)
+	b, _ := json.Marshal(signal.Message{Type: msgType, Data: raw})
+	return b
+}
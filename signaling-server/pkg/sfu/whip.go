@@ -0,0 +1,199 @@
+package sfu
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// how long an HTTP offer waits for our candidates, WHIP/WHEP answers carry all of them
+const gatheringTimeout = 5 * time.Second
+
+var (
+	ErrSessionExists = errors.New("client already has a session in this room")
+	ErrNoSession     = errors.New("no such session")
+)
+
+// codecs the room program can play to WHEP viewers, the WHIP publisher must send the same one
+var programVideoCodecs = map[string]webrtc.RTPCodecCapability{
+	"h264": {
+		MimeType: webrtc.MimeTypeH264, ClockRate: 90000,
+		SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+	},
+	"vp8": {MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+}
+
+/*
+newProgram makes the two tracks WHEP viewers of a room play. They exist before any
+publisher so a viewer can start watching first; the first WHIP publisher with a
+matching codec feeds them (see claimProgram).
+*/
+func (s *SFU) newProgram(roomId string) map[webrtc.RTPCodecType]*webrtc.TrackLocalStaticRTP {
+	program := make(map[webrtc.RTPCodecType]*webrtc.TrackLocalStaticRTP)
+	audio, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+		MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2,
+	}, "program-audio", roomId)
+	if err == nil {
+		program[webrtc.RTPCodecTypeAudio] = audio
+	}
+	video, err := webrtc.NewTrackLocalStaticRTP(s.programVideo, "program-video", roomId)
+	if err == nil {
+		program[webrtc.RTPCodecTypeVideo] = video
+	}
+	return program
+}
+
+// Publish answers a WHIP offer: the client's tracks are forwarded to the room like any SFU participant's
+func (s *SFU) Publish(roomId, clientId, offer string) (answer string, err error) {
+	return s.answerHTTP(roomId, clientId, offer, peerWHIP)
+}
+
+// Play answers a WHEP offer: the client gets the room program (audio + video of the WHIP publisher)
+func (s *SFU) Play(roomId, clientId, offer string) (answer string, err error) {
+	return s.answerHTTP(roomId, clientId, offer, peerWHEP)
+}
+
+func (s *SFU) answerHTTP(roomId, clientId, offer string, kind peerKind) (string, error) {
+	pc, err := s.api.NewPeerConnection(s.config)
+	if err != nil {
+		return "", err
+	}
+	r := s.room(roomId)
+	p := &peer{id: clientId, kind: kind, pc: pc}
+
+	// the slot is taken before negotiating, a second POST for the same client fails right away
+	r.mu.Lock()
+	if _, ok := r.peers[clientId]; ok {
+		r.mu.Unlock()
+		pc.Close()
+		return "", ErrSessionExists
+	}
+	r.peers[clientId] = p
+	r.mu.Unlock()
+
+	answer, err := r.answerHTTP(p, offer)
+	if err != nil {
+		s.Leave(roomId, clientId)
+		return "", err
+	}
+
+	// no DELETE coming from a client that just went away, ICE failure ends the session
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("[Room:%s] [Client:%s] SFU connection %s\n", roomId, clientId, state)
+		if state == webrtc.PeerConnectionStateFailed && r.peer(clientId) == p {
+			go s.Leave(roomId, clientId) // Leave closes pc, not from inside its own callback
+		}
+	})
+	return answer, nil
+}
+
+func (r *room) answerHTTP(p *peer, offer string) (string, error) {
+	if p.kind == peerWHIP {
+		p.pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+			r.forwardTrack(p, remote)
+		})
+	}
+
+	err := p.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer})
+	if err != nil {
+		return "", err
+	}
+
+	// after the remote description, so the tracks land in the transceivers the viewer offered
+	if p.kind == peerWHEP {
+		for _, track := range r.program {
+			sender, err := p.pc.AddTrack(track)
+			if err != nil {
+				return "", err
+			}
+			go drainRTCP(sender)
+		}
+	}
+
+	answer, err := p.pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	gathered := webrtc.GatheringCompletePromise(p.pc)
+	if err := p.pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	select {
+	case <-gathered:
+	case <-time.After(gatheringTimeout):
+		log.Printf("[Room:%s] [Client:%s] ICE gathering not complete, answering with what we have\n", r.id, p.id)
+	}
+	return p.pc.LocalDescription().SDP, nil
+}
+
+/*
+Trickle adds the candidates of a WHIP/WHEP PATCH body (application/trickle-ice-sdpfrag):
+
+	a=ice-ufrag:EsAw
+	m=audio 9 UDP/TLS/RTP/SAVPF 0
+	a=mid:0
+	a=candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0
+	a=end-of-candidates
+
+ICE restarts are not supported, a new ufrag is refused.
+*/
+func (s *SFU) Trickle(roomId, clientId, frag string) error {
+	s.mu.Lock()
+	r := s.rooms[roomId]
+	s.mu.Unlock()
+	if r == nil {
+		return ErrNoSession
+	}
+	p := r.peer(clientId)
+	if p == nil || p.kind == peerWebSocket {
+		return ErrNoSession
+	}
+
+	var mid string
+	var index uint16
+	sections := 0
+	for _, line := range strings.Split(frag, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "a=ice-ufrag:"):
+			if remote := p.pc.RemoteDescription(); remote != nil &&
+				!strings.Contains(remote.SDP, line) {
+				return errors.New("ICE restart is not supported")
+			}
+		case strings.HasPrefix(line, "m="):
+			index = uint16(sections)
+			sections++
+			mid = ""
+		case strings.HasPrefix(line, "a=mid:"):
+			mid = strings.TrimPrefix(line, "a=mid:")
+		case strings.HasPrefix(line, "a=candidate:"):
+			candidate := webrtc.ICECandidateInit{Candidate: strings.TrimPrefix(line, "a=")}
+			if mid != "" {
+				m := mid
+				candidate.SDPMid = &m
+			} else {
+				i := index
+				candidate.SDPMLineIndex = &i
+			}
+			if err := p.pc.AddICECandidate(candidate); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// HasSession tells if the client has a WHIP/WHEP session in the room
+func (s *SFU) HasSession(roomId, clientId string) bool {
+	s.mu.Lock()
+	r := s.rooms[roomId]
+	s.mu.Unlock()
+	if r == nil {
+		return false
+	}
+	p := r.peer(clientId)
+	return p != nil && p.kind != peerWebSocket
+}
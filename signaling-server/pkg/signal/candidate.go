@@ -0,0 +1,149 @@
+package signal
+
+import (
+	"strings"
+)
+
+/*
+CandidatePolicy decides which ICE candidates are relayed to the other peers of a room.
+Applied both to trickled "candidate" messages and to a=candidate lines inside offers/answers,
+where a restrictive policy also blanks the default address of the c= and a=rtcp lines.
+
+  - all:        everything (default)
+  - no-host:    drop host candidates, local IPs are never exposed
+  - relay-only: drop host, srflx and prflx, peers only see the TURN relay address
+  - mdns-only:  host candidates only as mDNS ".local" names, srflx/relay are kept
+*/
+type CandidatePolicy string
+
+const (
+	CandidatesAll       CandidatePolicy = "all"
+	CandidatesNoHost    CandidatePolicy = "no-host"
+	CandidatesRelayOnly CandidatePolicy = "relay-only"
+	CandidatesMDNSOnly  CandidatePolicy = "mdns-only"
+)
+
+func (p CandidatePolicy) Valid() bool {
+	switch p {
+	case "", CandidatesAll, CandidatesNoHost, CandidatesRelayOnly, CandidatesMDNSOnly:
+		return true
+	}
+	return false
+}
+
+/*
+Filter checks one candidate attribute, with or without the "a=" prefix:
+
+	candidate:<foundation> <component> <transport> <priority> <address> <port> typ <type> [raddr <ip> rport <port>] ...
+
+ok is false when the candidate must be dropped. A kept srflx/relay candidate still names
+the address it was derived from in raddr/rport, under any restrictive policy those are
+replaced with 0.0.0.0 / 0 the same way browsers do for mDNS.
+*/
+func (p CandidatePolicy) Filter(candidate string) (out string, ok bool) {
+	if p == "" || p == CandidatesAll {
+		return candidate, true
+	}
+
+	prefix := ""
+	if strings.HasPrefix(candidate, "a=") {
+		prefix, candidate = "a=", strings.TrimPrefix(candidate, "a=")
+	}
+	fields := strings.Fields(candidate)
+	if len(fields) == 0 {
+		return prefix + candidate, true // end-of-candidates marker, nothing to hide
+	}
+	if len(fields) < 8 || fields[6] != "typ" {
+		return "", false // can't tell what it exposes, so it does not pass a restrictive policy
+	}
+	address, typ := fields[4], fields[7]
+
+	switch p {
+	case CandidatesNoHost:
+		ok = typ != "host"
+	case CandidatesRelayOnly:
+		ok = typ == "relay"
+	case CandidatesMDNSOnly:
+		ok = typ != "host" || strings.HasSuffix(address, ".local")
+	}
+	if !ok {
+		return "", false
+	}
+
+	scrubbed := false
+	for i := 8; i+1 < len(fields); i += 2 {
+		switch fields[i] {
+		case "raddr":
+			scrubbed = scrubbed || fields[i+1] != "0.0.0.0"
+			fields[i+1] = "0.0.0.0"
+		case "rport":
+			scrubbed = scrubbed || fields[i+1] != "0"
+			fields[i+1] = "0"
+		}
+	}
+	if !scrubbed {
+		return prefix + candidate, true
+	}
+	return prefix + strings.Join(fields, " "), true
+}
+
+/*
+FilterSDP applies Filter to every a=candidate line. The c= and a=rtcp lines carry the address
+of the default candidate, usually the host one, so they get 0.0.0.0 (:: for IPv6) like a
+description without candidates; ICE does not use them. Everything else is kept byte for byte.
+changed is true when at least one line was removed or rewritten.
+*/
+func (p CandidatePolicy) FilterSDP(raw string) (filtered string, changed bool) {
+	if p == "" || p == CandidatesAll {
+		return raw, false
+	}
+
+	lines := strings.SplitAfter(raw, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		body := strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(body, "c=") || strings.HasPrefix(body, "a=rtcp:") {
+			out := blankAddress(body)
+			if out != body {
+				changed = true
+			}
+			kept = append(kept, out+line[len(body):])
+			continue
+		}
+		if !strings.HasPrefix(body, "a=candidate:") {
+			kept = append(kept, line)
+			continue
+		}
+
+		out, ok := p.Filter(body)
+		if out != body {
+			changed = true
+		}
+		if ok {
+			kept = append(kept, out+line[len(body):]) // keep the original line ending
+		}
+	}
+	return strings.Join(kept, ""), changed
+}
+
+// blankAddress replaces the address following IP4/IP6 in a c= or a=rtcp line
+func blankAddress(line string) string {
+	fields := strings.Fields(line)
+	for i := 0; i+1 < len(fields); i++ {
+		blank := ""
+		switch fields[i] {
+		case "IP4":
+			blank = "0.0.0.0"
+		case "IP6":
+			blank = "::"
+		default:
+			continue
+		}
+		if fields[i+1] == blank {
+			return line
+		}
+		fields[i+1] = blank
+		return strings.Join(fields, " ")
+	}
+	return line
+}
@@ -0,0 +1,65 @@
+package signal
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+/*
+Message is the JSON envelope the clients exchange over the WebSocket:
+
+	{"type":"offer","data":{"type":"offer","sdp":"v=0..."}}
+	{"type":"candidate","data":{"candidate":"candidate:...","sdpMid":"0"}}
+
+Data is kept raw so the hub can relay it without re-encoding.
+To/From address a message in rooms with more than one peer connection per client
+(broadcast): the sender sets "to", the hub sets "from".
+*/
+type Message struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+	To   string          `json:"to,omitempty"`
+	From string          `json:"from,omitempty"`
+}
+
+// SessionDescription is the data of an offer/answer, same shape as RTCSessionDescriptionInit
+type SessionDescription struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// Parse decodes a raw client message. ok is false for anything that is not a typed JSON message,
+// such messages are relayed untouched as before.
+func Parse(raw []byte) (msg Message, ok bool) {
+	if err := json.Unmarshal(raw, &msg); err != nil || msg.Type == "" {
+		return Message{}, false
+	}
+	return msg, true
+}
+
+// Error is returned to the sender of a message the server refused to relay
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func NewError(code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorMessage builds the frame sent back to the client: {"type":"error","data":{"code":..,"message":..,"for":"offer"}}
+func ErrorMessage(err *Error, forType string) []byte {
+	b, _ := json.Marshal(map[string]any{
+		"type": "error",
+		"data": map[string]string{
+			"code":    err.Code,
+			"message": err.Message,
+			"for":     forType,
+		},
+	})
+	return b
+}
@@ -0,0 +1,142 @@
+package signal
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+
+	"signaling-server-webrtc/utils"
+)
+
+// error codes sent back in {"type":"error"} when an offer/answer is refused
+const (
+	ErrSDPMissing         = "sdp-missing"
+	ErrSDPTooLarge        = "sdp-too-large"
+	ErrSDPMalformed       = "sdp-malformed"
+	ErrSDPTypeMismatch    = "sdp-type-mismatch"
+	ErrSDPMediaNotAllowed = "sdp-media-not-allowed"
+	ErrSDPCodecNotAllowed = "sdp-codec-not-allowed"
+)
+
+// these only make sense next to a real codec, they are never checked against AllowedCodecs
+var auxiliaryCodecs = map[string]bool{"rtx": true, "red": true, "ulpfec": true, "flexfec-03": true}
+
+/*
+SDPPolicy decides which session descriptions the server relays.
+
+  - MaxBytes: size limit of the raw SDP
+  - AllowedMedia: m= line types ("audio", "video", "application"), empty allows all
+  - AllowedCodecs: lower case rtpmap names ("opus", "vp8", ...), empty allows all.
+    Every active audio/video section must offer at least one of them.
+*/
+type SDPPolicy struct {
+	MaxBytes      int
+	AllowedMedia  map[string]bool
+	AllowedCodecs map[string]bool
+}
+
+// SDPPolicyFromEnv reads SDP_MAX_BYTES, SDP_ALLOWED_MEDIA and SDP_ALLOWED_CODECS (comma separated)
+func SDPPolicyFromEnv() SDPPolicy {
+	return SDPPolicy{
+		MaxBytes:      utils.GetEnvInt("SDP_MAX_BYTES", 64*1024),
+		AllowedMedia:  listSet(utils.GetEnv("SDP_ALLOWED_MEDIA")),
+		AllowedCodecs: listSet(utils.GetEnv("SDP_ALLOWED_CODECS")),
+	}
+}
+
+func listSet(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+// ValidateDescription checks the data of an "offer" or "answer" message
+func (p SDPPolicy) ValidateDescription(msgType string, data json.RawMessage) (*sdp.SessionDescription, *Error) {
+	var desc SessionDescription
+	if err := json.Unmarshal(data, &desc); err != nil || desc.SDP == "" {
+		return nil, NewError(ErrSDPMissing, "%s has no sdp", msgType)
+	}
+	if desc.Type != "" && desc.Type != msgType {
+		return nil, NewError(ErrSDPTypeMismatch, "message type %q carries a %q description", msgType, desc.Type)
+	}
+	return p.Validate(desc.SDP)
+}
+
+// Validate parses the raw SDP and applies the policy
+func (p SDPPolicy) Validate(raw string) (*sdp.SessionDescription, *Error) {
+	if p.MaxBytes > 0 && len(raw) > p.MaxBytes {
+		return nil, NewError(ErrSDPTooLarge, "sdp is %d bytes, limit is %d", len(raw), p.MaxBytes)
+	}
+
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.UnmarshalString(raw); err != nil {
+		return nil, NewError(ErrSDPMalformed, "%s", err.Error())
+	}
+	if len(parsed.MediaDescriptions) == 0 {
+		return nil, NewError(ErrSDPMalformed, "sdp has no media sections")
+	}
+
+	for i, media := range parsed.MediaDescriptions {
+		kind := strings.ToLower(media.MediaName.Media)
+		if media.MediaName.Port.Value == 0 {
+			continue // rejected/stopped section, nothing will flow
+		}
+		if len(p.AllowedMedia) > 0 && !p.AllowedMedia[kind] {
+			return nil, NewError(ErrSDPMediaNotAllowed, "media section %d of type %q is not allowed", i, kind)
+		}
+		if len(p.AllowedCodecs) > 0 && (kind == "audio" || kind == "video") && !hasAllowedCodec(media, p.AllowedCodecs) {
+			return nil, NewError(ErrSDPCodecNotAllowed, "media section %d (%s) offers none of the allowed codecs", i, kind)
+		}
+	}
+
+	return parsed, nil
+}
+
+// rtpmap values look like "111 opus/48000/2"
+func hasAllowedCodec(media *sdp.MediaDescription, allowed map[string]bool) bool {
+	for _, attr := range media.Attributes {
+		if attr.Key != "rtpmap" {
+			continue
+		}
+		fields := strings.Fields(attr.Value)
+		if len(fields) != 2 {
+			continue
+		}
+		name := strings.ToLower(strings.SplitN(fields[1], "/", 2)[0])
+		if !auxiliaryCodecs[name] && allowed[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// SendingSection returns the index of the first active audio/video section that sends media
+// (sendrecv or sendonly, the default when no direction is given), -1 if it only receives
+func SendingSection(desc *sdp.SessionDescription) int {
+	sessionDirection := direction(desc.Attributes, "sendrecv")
+	for i, media := range desc.MediaDescriptions {
+		kind := strings.ToLower(media.MediaName.Media)
+		if media.MediaName.Port.Value == 0 || (kind != "audio" && kind != "video") {
+			continue
+		}
+		if d := direction(media.Attributes, sessionDirection); d == "sendrecv" || d == "sendonly" {
+			return i
+		}
+	}
+	return -1
+}
+
+func direction(attrs []sdp.Attribute, def string) string {
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "sendrecv", "sendonly", "recvonly", "inactive":
+			return attr.Key
+		}
+	}
+	return def
+}
@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"signaling-server-webrtc/pkg/store"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+Snapshot is the hub's rooms at one instant: slots, who is connected, what is queued
+for whom, wait timers. A new process restores it and goes on where the old one
+stopped, with the old WebSockets (handoff, roles are not sent again) or waiting for the
+clients to reconnect (snapshot file, they get their roles on reconnect as usual).
+*/
+type Snapshot struct {
+	Node  string         `json:"node"`
+	Taken time.Time      `json:"taken"`
+	Rooms []RoomSnapshot `json:"rooms"`
+}
+
+type RoomSnapshot struct {
+	ID        string           `json:"id"`
+	Room      json.RawMessage  `json:"room"` // store.EncodeRoom: options incl. the server set fields, creator
+	Clients   []ClientSnapshot `json:"clients"`
+	WaitSince *time.Time       `json:"waitSince,omitempty"`
+	Reminders int              `json:"reminders,omitempty"`
+}
+
+type ClientSnapshot struct {
+	ID      string            `json:"id"`
+	Conn    int               `json:"conn"`           // index of its handed off WebSocket, -1 = none
+	Held    bool              `json:"held,omitempty"` // was connected, keeps its slot for RECONNECT_GRACE
+	Queued  []string          `json:"queued,omitempty"`
+	Pending []PendingSnapshot `json:"pending,omitempty"`
+}
+
+type PendingSnapshot struct {
+	Data     string    `json:"data"`
+	QueuedAt time.Time `json:"queuedAt"`
+}
+
+// snapshot describes this node's rooms. conn numbers the WebSockets that go along (-1 = not carried over),
+// their queued messages are taken out of Send so only number stopped clients. Must run in the Run goroutine.
+func (h *Hub) snapshot(conn func(c *Client) int) Snapshot {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+
+	s := Snapshot{Node: h.node, Taken: time.Now()}
+	for roomId, clients := range h.Rooms {
+		room, err := h.store.Lookup(roomId)
+		if err != nil {
+			room = h.localRoom(roomId)
+		}
+		raw, err := store.EncodeRoom(room)
+		if err != nil {
+			utils.LogRoom(roomId, "Nil", "snapshot: %s", err)
+			continue
+		}
+
+		rs := RoomSnapshot{ID: roomId, Room: raw}
+		if w := h.waiting[roomId]; w != nil {
+			since := w.since
+			rs.WaitSince, rs.Reminders = &since, w.reminders
+		}
+		for clientId, c := range clients {
+			cs := ClientSnapshot{ID: clientId, Conn: -1}
+			if c != nil {
+				cs.Conn = conn(c)
+				cs.Held = cs.Conn < 0
+				if cs.Conn >= 0 {
+					cs.Queued = drainSend(c)
+				}
+			} else {
+				cs.Held = h.timers.Pending(graceTimerKey(roomId, clientId))
+			}
+			if q := h.pending[roomId][clientId]; q != nil {
+				for _, m := range q.items {
+					cs.Pending = append(cs.Pending, PendingSnapshot{Data: string(m.data), QueuedAt: m.queuedAt})
+				}
+			}
+			rs.Clients = append(rs.Clients, cs)
+		}
+		s.Rooms = append(s.Rooms, rs)
+	}
+	return s
+}
+
+func drainSend(c *Client) []string {
+	var queued []string
+	for {
+		select {
+		case m, ok := <-c.Send:
+			if !ok {
+				return queued
+			}
+			queued = append(queued, string(m))
+		default:
+			return queued
+		}
+	}
+}
+
+/*
+Restore puts a handed off snapshot back, before Run is started. conns are the WebSockets
+indexed by ClientSnapshot.Conn, connected clients without one keep their slot for the
+reconnect grace. Clients of "sfu" rooms get a fresh offer, media does not survive the
+old process.
+*/
+func (h *Hub) Restore(s Snapshot, conns []*websocket.Conn) {
+	h.restore(s, conns, reconnectGrace)
+}
+
+// restore holds the slots of clients without a socket for grace, 0 keeps them until the room expires
+func (h *Hub) restore(s Snapshot, conns []*websocket.Conn, grace time.Duration) {
+	var started []*Client
+
+	h.Mu.Lock()
+	for _, rs := range s.Rooms {
+		room, err := store.DecodeRoom(rs.ID, rs.Room)
+		if err != nil {
+			utils.LogRoom(rs.ID, "Nil", "restore: %s", err)
+			continue
+		}
+		for _, cs := range rs.Clients {
+			room.Clients = append(room.Clients, cs.ID)
+		}
+		// a shared store still has it
+		if err := h.store.Create(room); err != nil && !errors.Is(err, store.ErrRoomExists) {
+			utils.LogRoom(rs.ID, "Nil", "restore: room store: %s", err)
+			continue
+		}
+		h.touchRoom(rs.ID)
+
+		h.Rooms[rs.ID] = make(map[string]*Client)
+		opts := room.Options
+		h.Options[rs.ID] = &opts
+
+		live := false
+		for _, cs := range rs.Clients {
+			h.Rooms[rs.ID][cs.ID] = nil
+			h.restorePending(rs.ID, cs)
+
+			if cs.Conn >= 0 && cs.Conn < len(conns) && conns[cs.Conn] != nil {
+				c := NewClient(conns[cs.Conn], rs.ID, cs.ID)
+				for _, m := range cs.Queued {
+					c.Send <- []byte(m)
+				}
+				h.Rooms[rs.ID][cs.ID] = c
+				if _, err := h.store.Connect(rs.ID, cs.ID, h.node); err != nil {
+					utils.LogRoom(rs.ID, cs.ID, "restore: room store: connect failed: %s", err)
+				}
+				started = append(started, c)
+				live = true
+				continue
+			}
+			// the old process never disconnected it
+			h.store.Disconnect(rs.ID, cs.ID, s.Node)
+			if cs.Held && grace > 0 {
+				h.holdSlot(rs.ID, cs.ID, grace)
+			}
+		}
+
+		if rs.WaitSince != nil && live { // otherwise it starts over on reconnect
+			h.waiting[rs.ID] = &waitState{since: *rs.WaitSince, reminders: rs.Reminders}
+		}
+		utils.LogRoom(rs.ID, "Nil", "♻️ room restored, %d slots", len(rs.Clients))
+	}
+	h.Mu.Unlock()
+
+	for roomId := range h.waiting {
+		h.scheduleWaitTimer(roomId)
+	}
+	for _, c := range started {
+		c.Start(h)
+		if h.usesSFU(c.RoomID) {
+			h.joinSFU(c)
+		}
+	}
+}
+
+// restorePending puts back what was held for a client, in its original order and age. Needs Mu.
+func (h *Hub) restorePending(roomId string, cs ClientSnapshot) {
+	if len(cs.Pending) == 0 {
+		return
+	}
+	q := &pendingQueue{}
+	for _, m := range cs.Pending {
+		q.items = append(q.items, pendingMessage{data: []byte(m.Data), queuedAt: m.QueuedAt})
+	}
+	if h.pending[roomId] == nil {
+		h.pending[roomId] = make(map[string]*pendingQueue)
+	}
+	h.pending[roomId][cs.ID] = q
+	if !h.timers.Pending(pendingTimerKey(roomId)) {
+		h.timers.Schedule(pendingTimerKey(roomId), maxPendingAge, func() { h.prunePending(roomId) })
+	}
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is the single process store, everything is gone with the process
+type Memory struct {
+	mu    sync.Mutex
+	rooms map[string]*memRoom
+}
+
+type memRoom struct {
+	room      Room
+	slots     map[string]struct{}
+	connected map[string]string
+	deadline  time.Time // zero = no expiry
+}
+
+func NewMemory() *Memory {
+	return &Memory{rooms: make(map[string]*memRoom)}
+}
+
+func (m *Memory) Create(room Room) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.get(room.ID) != nil {
+		return ErrRoomExists
+	}
+	r := &memRoom{room: room, slots: make(map[string]struct{}), connected: make(map[string]string)}
+	for _, id := range room.Clients {
+		r.slots[id] = struct{}{}
+	}
+	m.rooms[room.ID] = r
+	return nil
+}
+
+func (m *Memory) Reserve(roomId, clientId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.get(roomId)
+	if r == nil {
+		return ErrRoomNotFound
+	}
+	r.slots[clientId] = struct{}{}
+	return nil
+}
+
+func (m *Memory) Lookup(roomId string) (Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.get(roomId)
+	if r == nil {
+		return Room{}, ErrRoomNotFound
+	}
+	return r.snapshot(), nil
+}
+
+func (m *Memory) List() ([]Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rooms := make([]Room, 0, len(m.rooms))
+	for id := range m.rooms {
+		if r := m.get(id); r != nil {
+			rooms = append(rooms, r.snapshot())
+		}
+	}
+	return rooms, nil
+}
+
+func (m *Memory) Connect(roomId, clientId, node string) (Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.get(roomId)
+	if r == nil {
+		return Room{}, ErrRoomNotFound
+	}
+	r.connected[clientId] = node
+	return r.snapshot(), nil
+}
+
+func (m *Memory) Disconnect(roomId, clientId, node string) (Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.get(roomId)
+	if r == nil {
+		return Room{}, ErrRoomNotFound
+	}
+	if r.connected[clientId] == node {
+		delete(r.connected, clientId)
+	}
+	return r.snapshot(), nil
+}
+
+func (m *Memory) Release(roomId, clientId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.get(roomId)
+	if r == nil {
+		return nil
+	}
+	delete(r.slots, clientId)
+	delete(r.connected, clientId)
+	if len(r.slots) == 0 {
+		delete(m.rooms, roomId)
+	}
+	return nil
+}
+
+func (m *Memory) Delete(roomId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.rooms, roomId)
+	return nil
+}
+
+func (m *Memory) Expire(roomId string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.get(roomId)
+	if r == nil {
+		return ErrRoomNotFound
+	}
+	if ttl <= 0 {
+		r.deadline = time.Time{}
+	} else {
+		r.deadline = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// get returns the room if it exists and has not expired, expired ones are dropped on the way. Needs mu.
+func (m *Memory) get(roomId string) *memRoom {
+	r := m.rooms[roomId]
+	if r == nil {
+		return nil
+	}
+	if !r.deadline.IsZero() && time.Now().After(r.deadline) {
+		delete(m.rooms, roomId)
+		return nil
+	}
+	return r
+}
+
+func (r *memRoom) snapshot() Room {
+	room := r.room
+	room.Clients = make([]string, 0, len(r.slots))
+	for id := range r.slots {
+		room.Clients = append(room.Clients, id)
+	}
+	sort.Strings(room.Clients)
+	room.Connected = make(map[string]string, len(r.connected))
+	for id, node := range r.connected {
+		room.Connected[id] = node
+	}
+	return room
+}
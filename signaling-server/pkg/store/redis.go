@@ -0,0 +1,261 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisTimeout = 2 * time.Second
+
+/*
+Redis keeps the rooms of every instance sharing REDIS_URL:
+
+	<prefix>room:<id>        JSON options and creation time
+	<prefix>room:<id>:slots  set of reserved clientIds
+	<prefix>room:<id>:online hash clientId -> node holding its socket
+	<prefix>rooms            set of room ids, for List
+
+Scripts keep "room exists" checks and the slot updates in one step.
+*/
+type Redis struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+func NewRedis(url, prefix string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	r := &Redis{rdb: redis.NewClient(opts), prefix: prefix}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := r.rdb.Ping(ctx).Err(); err != nil {
+		r.rdb.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// KEYS: room, slots, rooms. ARGV: record, roomId, clientIds...
+var createScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then return 0 end
+redis.call("SET", KEYS[1], ARGV[1])
+if #ARGV > 2 then redis.call("SADD", KEYS[2], unpack(ARGV, 3)) end
+redis.call("SADD", KEYS[3], ARGV[2])
+return 1`)
+
+// KEYS: room, slots. ARGV: clientId. The slots inherit the room's expiry.
+var reserveScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then return 0 end
+redis.call("SADD", KEYS[2], ARGV[1])
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then redis.call("PEXPIRE", KEYS[2], ttl) end
+return 1`)
+
+// KEYS: room, slots, rooms, online. ARGV: clientId, roomId
+var releaseScript = redis.NewScript(`
+redis.call("SREM", KEYS[2], ARGV[1])
+redis.call("HDEL", KEYS[4], ARGV[1])
+if redis.call("SCARD", KEYS[2]) == 0 then
+	redis.call("DEL", KEYS[1], KEYS[4])
+	redis.call("SREM", KEYS[3], ARGV[2])
+end
+return 1`)
+
+// KEYS: room, online. ARGV: clientId, node. Returns the online hash after the update, false if no room.
+var connectScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then return false end
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[2])
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then redis.call("PEXPIRE", KEYS[2], ttl) end
+return redis.call("HGETALL", KEYS[2])`)
+
+// KEYS: room, online. ARGV: clientId, node
+var disconnectScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then return false end
+if redis.call("HGET", KEYS[2], ARGV[1]) == ARGV[2] then redis.call("HDEL", KEYS[2], ARGV[1]) end
+return redis.call("HGETALL", KEYS[2])`)
+
+// KEYS: room, slots, online. ARGV: ttl in ms, <= 0 persists
+var expireScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then return 0 end
+local ttl = tonumber(ARGV[1])
+for i = 1, 3 do
+	if ttl > 0 then redis.call("PEXPIRE", KEYS[i], ttl) else redis.call("PERSIST", KEYS[i]) end
+end
+return 1`)
+
+func (r *Redis) Create(room Room) error {
+	rec, err := EncodeRoom(room)
+	if err != nil {
+		return err
+	}
+	args := []any{rec, room.ID}
+	for _, id := range room.Clients {
+		args = append(args, id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	created, err := createScript.Run(ctx, r.rdb, r.keys(room.ID)[:3], args...).Int()
+	if err != nil {
+		return err
+	}
+	if created == 0 {
+		return ErrRoomExists
+	}
+	return nil
+}
+
+func (r *Redis) Reserve(roomId, clientId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	ok, err := reserveScript.Run(ctx, r.rdb, r.keys(roomId)[:2], clientId).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrRoomNotFound
+	}
+	return nil
+}
+
+func (r *Redis) Lookup(roomId string) (Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return r.lookup(ctx, roomId)
+}
+
+func (r *Redis) lookup(ctx context.Context, roomId string) (Room, error) {
+	keys := r.keys(roomId)
+	var rec *redis.StringCmd
+	var slots *redis.StringSliceCmd
+	var online *redis.MapStringStringCmd
+	_, err := r.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		rec = p.Get(ctx, keys[0])
+		slots = p.SMembers(ctx, keys[1])
+		online = p.HGetAll(ctx, keys[3])
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return Room{}, ErrRoomNotFound
+	}
+	if err != nil {
+		return Room{}, err
+	}
+
+	room, err := DecodeRoom(roomId, []byte(rec.Val()))
+	if err != nil {
+		return Room{}, err
+	}
+	room.Clients = slots.Val()
+	sort.Strings(room.Clients)
+	room.Connected = online.Val()
+	return room, nil
+}
+
+// List also forgets ids whose room expired
+func (r *Redis) List() ([]Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	ids, err := r.rdb.SMembers(ctx, r.prefix+"rooms").Result()
+	if err != nil {
+		return nil, err
+	}
+	rooms := make([]Room, 0, len(ids))
+	for _, id := range ids {
+		room, err := r.lookup(ctx, id)
+		if errors.Is(err, ErrRoomNotFound) {
+			r.rdb.SRem(ctx, r.prefix+"rooms", id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
+
+func (r *Redis) Connect(roomId, clientId, node string) (Room, error) {
+	return r.presence(connectScript, roomId, clientId, node)
+}
+
+func (r *Redis) Disconnect(roomId, clientId, node string) (Room, error) {
+	return r.presence(disconnectScript, roomId, clientId, node)
+}
+
+// presence runs the connect/disconnect script, its online hash wins over the one read by lookup
+func (r *Redis) presence(script *redis.Script, roomId, clientId, node string) (Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	keys := r.keys(roomId)
+	res, err := script.Run(ctx, r.rdb, []string{keys[0], keys[3]}, clientId, node).StringSlice()
+	if errors.Is(err, redis.Nil) {
+		return Room{}, ErrRoomNotFound
+	}
+	if err != nil {
+		return Room{}, err
+	}
+	room, err := r.lookup(ctx, roomId)
+	if err != nil {
+		return Room{}, err
+	}
+	room.Connected = make(map[string]string, len(res)/2)
+	for i := 0; i+1 < len(res); i += 2 {
+		room.Connected[res[i]] = res[i+1]
+	}
+	return room, nil
+}
+
+func (r *Redis) Release(roomId, clientId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return releaseScript.Run(ctx, r.rdb, r.keys(roomId), clientId, roomId).Err()
+}
+
+func (r *Redis) Delete(roomId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	keys := r.keys(roomId)
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, keys[0], keys[1], keys[3])
+		p.SRem(ctx, keys[2], roomId)
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) Expire(roomId string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	keys := r.keys(roomId)
+	ok, err := expireScript.Run(ctx, r.rdb, []string{keys[0], keys[1], keys[3]}, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrRoomNotFound
+	}
+	return nil
+}
+
+func (r *Redis) Close() error {
+	return r.rdb.Close()
+}
+
+func (r *Redis) keys(roomId string) []string {
+	room := r.prefix + "room:" + roomId
+	return []string{room, room + ":slots", r.prefix + "rooms", room + ":online"}
+}
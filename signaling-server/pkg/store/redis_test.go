@@ -0,0 +1,220 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"signaling-server-webrtc/pkg/types"
+)
+
+func newTestRedis(t *testing.T) (*Redis, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	r, err := NewRedis("redis://"+mr.Addr(), "test:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, mr
+}
+
+func TestRedisCreate(t *testing.T) {
+	r, mr := newTestRedis(t)
+
+	room := Room{ID: "r1", Options: types.RoomOptions{Topology: "sfu"}, Clients: []string{"b", "a"}, Node: "n1"}
+	if err := r.Create(room); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Create(room); !errors.Is(err, ErrRoomExists) {
+		t.Fatalf("second create: got %v, want ErrRoomExists", err)
+	}
+
+	got, err := r.Lookup("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Clients, []string{"a", "b"}) {
+		t.Errorf("clients %v, want [a b]", got.Clients)
+	}
+	if got.Options.Topology != "sfu" || got.Node != "n1" {
+		t.Errorf("options/node not stored: %+v", got)
+	}
+	if ok, _ := mr.SIsMember("test:rooms", "r1"); !ok {
+		t.Error("room id missing from the rooms set")
+	}
+
+	// a room without slots yet
+	if err := r.Create(Room{ID: "r2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.Lookup("r2"); err != nil || len(got.Clients) != 0 {
+		t.Errorf("empty room: %+v, %v", got, err)
+	}
+}
+
+func TestRedisReserve(t *testing.T) {
+	r, mr := newTestRedis(t)
+
+	if err := r.Reserve("nope", "a"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("reserve in a missing room: got %v, want ErrRoomNotFound", err)
+	}
+	if mr.Exists("test:room:nope:slots") {
+		t.Error("reserve created slots for a missing room")
+	}
+
+	if err := r.Create(Room{ID: "r1", Clients: []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Expire("r1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reserve("r1", "b"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := r.Lookup("r1")
+	if !got.Has("b") {
+		t.Errorf("slot b not reserved: %v", got.Clients)
+	}
+	if ttl := mr.TTL("test:room:r1:slots"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("slots ttl %s, want the room's", ttl)
+	}
+}
+
+func TestRedisConnectDisconnect(t *testing.T) {
+	r, _ := newTestRedis(t)
+
+	if _, err := r.Connect("nope", "a", "n1"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("connect to a missing room: got %v, want ErrRoomNotFound", err)
+	}
+	if err := r.Create(Room{ID: "r1", Clients: []string{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := r.Connect("r1", "a", "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(room.Connected, map[string]string{"a": "n1"}) {
+		t.Errorf("after connect a: %v", room.Connected)
+	}
+	room, _ = r.Connect("r1", "b", "n2")
+	if !reflect.DeepEqual(room.Connected, map[string]string{"a": "n1", "b": "n2"}) {
+		t.Errorf("after connect b: %v", room.Connected)
+	}
+
+	// a moved to n2 meanwhile, the late disconnect of n1 must not take it offline
+	r.Connect("r1", "a", "n2")
+	room, err = r.Disconnect("r1", "a", "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Connected["a"] != "n2" {
+		t.Errorf("stale disconnect removed a: %v", room.Connected)
+	}
+	room, _ = r.Disconnect("r1", "a", "n2")
+	if _, ok := room.Connected["a"]; ok {
+		t.Errorf("a still connected: %v", room.Connected)
+	}
+	if !room.Has("a") {
+		t.Error("disconnect dropped the slot")
+	}
+}
+
+func TestRedisRelease(t *testing.T) {
+	r, mr := newTestRedis(t)
+
+	if err := r.Create(Room{ID: "r1", Clients: []string{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	r.Connect("r1", "a", "n1")
+
+	if err := r.Release("r1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	room, err := r.Lookup("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Has("a") || len(room.Connected) != 0 {
+		t.Errorf("a not released: %+v", room)
+	}
+
+	// the last slot takes the room with it
+	if err := r.Release("r1", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Lookup("r1"); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("lookup after the last release: got %v, want ErrRoomNotFound", err)
+	}
+	if ok, _ := mr.SIsMember("test:rooms", "r1"); ok {
+		t.Error("room id still in the rooms set")
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("keys left behind: %v", keys)
+	}
+}
+
+func TestRedisExpire(t *testing.T) {
+	r, mr := newTestRedis(t)
+
+	if err := r.Expire("nope", time.Minute); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("expire a missing room: got %v, want ErrRoomNotFound", err)
+	}
+	if err := r.Create(Room{ID: "r1", Clients: []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	r.Connect("r1", "a", "n1")
+
+	if err := r.Expire("r1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"test:room:r1", "test:room:r1:slots", "test:room:r1:online"} {
+		if ttl := mr.TTL(key); ttl != time.Minute {
+			t.Errorf("%s ttl %s, want 1m", key, ttl)
+		}
+	}
+
+	// ttl 0 keeps it until Delete
+	if err := r.Expire("r1", 0); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(time.Hour)
+	if _, err := r.Lookup("r1"); err != nil {
+		t.Fatalf("persisted room gone: %v", err)
+	}
+
+	r.Expire("r1", time.Minute)
+	mr.FastForward(2 * time.Minute)
+	if _, err := r.Lookup("r1"); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("lookup after expiry: got %v, want ErrRoomNotFound", err)
+	}
+	// List forgets it too
+	if rooms, err := r.List(); err != nil || len(rooms) != 0 {
+		t.Errorf("list after expiry: %v, %v", rooms, err)
+	}
+	if ok, _ := mr.SIsMember("test:rooms", "r1"); ok {
+		t.Error("expired id still in the rooms set after List")
+	}
+}
+
+func TestRedisDelete(t *testing.T) {
+	r, mr := newTestRedis(t)
+
+	if err := r.Create(Room{ID: "r1", Clients: []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	r.Connect("r1", "a", "n1")
+	if err := r.Delete("r1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Lookup("r1"); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("lookup after delete: got %v, want ErrRoomNotFound", err)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("keys left behind: %v", keys)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Package store keeps the room registry: which rooms exist, their options and the
+client slots reserved in them. Live connections stay in the hub of the node that
+holds the socket, only reservations go through a RoomStore, so with a shared store
+(Redis) a room created on one instance can be joined on another and survives restarts.
+*/
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"signaling-server-webrtc/pkg/types"
+	"signaling-server-webrtc/utils"
+)
+
+var (
+	ErrRoomNotFound = errors.New("room not found")
+	ErrRoomExists   = errors.New("room already exists")
+)
+
+// Room is one stored reservation set
+type Room struct {
+	ID        string
+	Options   types.RoomOptions
+	Clients   []string          // reserved slots, connected or not
+	Connected map[string]string // clientId -> node holding its socket
+	Node      string            // node that created the room, its owner with cluster routing
+	CreatedAt time.Time
+}
+
+func (r Room) Has(clientId string) bool {
+	for _, id := range r.Clients {
+		if id == clientId {
+			return true
+		}
+	}
+	return false
+}
+
+type RoomStore interface {
+	// Create stores a new room with the given slots, ErrRoomExists if the id is taken
+	Create(room Room) error
+	// Reserve adds a slot to an existing room, ErrRoomNotFound otherwise
+	Reserve(roomId, clientId string) error
+	// Lookup returns the room, ErrRoomNotFound if it does not exist (or expired)
+	Lookup(roomId string) (Room, error)
+	List() ([]Room, error)
+	// Connect records which node holds the client's socket and returns the room right after
+	Connect(roomId, clientId, node string) (Room, error)
+	// Disconnect undoes Connect if node still holds the socket, the room is returned like by Connect
+	Disconnect(roomId, clientId, node string) (Room, error)
+	// Release drops a slot, and the room with its last one
+	Release(roomId, clientId string) error
+	Delete(roomId string) error
+	// Expire deletes the room after ttl unless called again, ttl <= 0 keeps it until Delete
+	Expire(roomId string, ttl time.Duration) error
+	Close() error
+}
+
+// FromEnv picks the store from ROOM_STORE: "memory" (default) or "redis" (REDIS_URL)
+func FromEnv() (RoomStore, error) {
+	switch kind := utils.GetEnv("ROOM_STORE"); kind {
+	case "", "memory":
+		return NewMemory(), nil
+	case "redis":
+		url := utils.GetEnv("REDIS_URL")
+		if url == "" {
+			return nil, errors.New("REDIS_URL must be set for ROOM_STORE=redis")
+		}
+		prefix := utils.GetEnv("REDIS_KEY_PREFIX")
+		if prefix == "" {
+			prefix = "signal:"
+		}
+		return NewRedis(url, prefix)
+	default:
+		return nil, fmt.Errorf("unknown ROOM_STORE %q", kind)
+	}
+}
+
+// record is how options are serialized, RoomOptions hides the server set fields from JSON
+type record struct {
+	Options   types.RoomOptions `json:"options"`
+	Presenter string            `json:"presenter,omitempty"`
+	Private   bool              `json:"private,omitempty"`
+	Offerer   string            `json:"offerer,omitempty"`
+	Node      string            `json:"node,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// EncodeRoom serializes everything but the id and the slots, also used by hub snapshots
+func EncodeRoom(r Room) ([]byte, error) {
+	return json.Marshal(record{
+		Options:   r.Options,
+		Presenter: r.Options.Presenter,
+		Private:   r.Options.Private,
+		Offerer:   r.Options.Offerer,
+		Node:      r.Node,
+		CreatedAt: r.CreatedAt,
+	})
+}
+
+func DecodeRoom(id string, raw []byte) (Room, error) {
+	var rec record
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return Room{}, err
+	}
+	rec.Options.Presenter = rec.Presenter
+	rec.Options.Private = rec.Private
+	rec.Options.Offerer = rec.Offerer
+	return Room{ID: id, Options: rec.Options, Node: rec.Node, CreatedAt: rec.CreatedAt}, nil
+}
@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"signaling-server-webrtc/pkg/bus"
+)
+
+/*
+Stream is the fallback transport for networks that block WebSocket upgrades: the
+client receives its messages as Server-Sent Events and sends each one with a POST,
+both on /api/rooms/{roomId}/messages (see srv/stream.go). The SSE response lives as
+long as the client is connected; a POST reaching another instance goes over the bus
+to the one serving the stream.
+*/
+type Stream struct {
+	in   chan []byte // POSTed, read by the client's ReadPump
+	out  chan []byte // unbuffered, a message is written out before the next one is taken
+	done chan struct{}
+	once sync.Once
+
+	mu        sync.Mutex
+	forwarded [][]byte // POSTed on another node, queued by the hub which must not block
+	wake      chan struct{}
+}
+
+// messages POSTed on other nodes waiting for the ReadPump, more are dropped
+const maxForwarded = 64
+
+var (
+	ErrStreamClosed = errors.New("stream closed")
+	ErrNoStream     = errors.New("no open message stream")
+)
+
+// NewStreamClient is a client whose messages go through the returned Stream, Start runs it once it is registered
+func NewStreamClient(roomId, clientId string) (*Client, *Stream) {
+	s := &Stream{
+		in:   make(chan []byte, 16),
+		out:  make(chan []byte),
+		done: make(chan struct{}),
+		wake: make(chan struct{}, 1),
+	}
+	return newClient(s, roomId, clientId), s
+}
+
+// Stream returns the SSE stream of a client connected to this node, nil if it has none
+func (h *Hub) Stream(roomId, clientId string) *Stream {
+	if c := h.GetClientFromRoom(roomId, clientId); c != nil {
+		if s, ok := c.transport.(*Stream); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// PostMessage hands msg to the stream of the client, on this node or, over the bus, on the one holding it
+func (h *Hub) PostMessage(ctx context.Context, roomId, clientId string, msg []byte) error {
+	if s := h.Stream(roomId, clientId); s != nil {
+		return s.Post(ctx, msg)
+	}
+	room, err := h.store.Lookup(roomId)
+	if err != nil {
+		return ErrNoStream
+	}
+	if node, ok := room.Connected[clientId]; ok && node != h.node {
+		h.publish(bus.Message{Kind: bus.KindPost, RoomID: roomId, From: clientId, Data: msg})
+		return nil
+	}
+	return ErrNoStream
+}
+
+// Post hands a message of the client to the hub, in the order they are posted
+func (s *Stream) Post(ctx context.Context, msg []byte) error {
+	select {
+	case s.in <- msg:
+		return nil
+	case <-s.done:
+		return ErrStreamClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Messages are the ones to write to the SSE response
+func (s *Stream) Messages() <-chan []byte {
+	return s.out
+}
+
+// Done is closed when the client left: the hub closed it (kicked, room closed, replaced) or the response ended
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// forward queues a message POSTed on another node without blocking, false if the queue is full
+func (s *Stream) forward(msg []byte) bool {
+	s.mu.Lock()
+	if len(s.forwarded) >= maxForwarded {
+		s.mu.Unlock()
+		return false
+	}
+	s.forwarded = append(s.forwarded, msg)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (s *Stream) Read() ([]byte, error) {
+	for {
+		s.mu.Lock()
+		if len(s.forwarded) > 0 {
+			msg := s.forwarded[0]
+			s.forwarded = s.forwarded[1:]
+			s.mu.Unlock()
+			return msg, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case msg := <-s.in:
+			return msg, nil
+		case <-s.wake:
+		case <-s.done:
+			return nil, ErrStreamClosed
+		}
+	}
+}
+
+func (s *Stream) Write(msg []byte) error {
+	select {
+	case s.out <- msg:
+		return nil
+	case <-s.done:
+		return ErrStreamClosed
+	}
+}
+
+func (s *Stream) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return nil
+}
+
+// streams are not handed off, the client reconnects to the next process
+func (s *Stream) interrupt() {
+	s.Close()
+}
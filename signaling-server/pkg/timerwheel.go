@@ -0,0 +1,98 @@
+package pkg
+
+import "time"
+
+/*
+TimerWheel is a hashed timing wheel used by the Hub for all its delayed work
+(room wait reminders, auto close, ...). It is advanced by a single ticker in
+Hub.Run, so there is no sleeping goroutine per connection.
+
+	slots: [0][1][2]...[size-1]   <- pos moves one slot per tick
+	        |
+	        +-> timers due in this slot (rounds == 0) or in a later lap (rounds > 0)
+
+Not safe for concurrent use, it must only be touched from the Hub.Run goroutine.
+*/
+type TimerWheel struct {
+	tick  time.Duration
+	slots []map[string]*wheelTimer
+	pos   int
+	byKey map[string]*wheelTimer
+}
+
+type wheelTimer struct {
+	slot   int
+	rounds int // full laps of the wheel left before it fires
+	fn     func()
+}
+
+func NewTimerWheel(tick time.Duration, size int) *TimerWheel {
+	slots := make([]map[string]*wheelTimer, size)
+	for i := range slots {
+		slots[i] = make(map[string]*wheelTimer)
+	}
+	return &TimerWheel{
+		tick:  tick,
+		slots: slots,
+		byKey: make(map[string]*wheelTimer),
+	}
+}
+
+// Schedule runs fn after d. A timer already scheduled under the same key is replaced.
+func (tw *TimerWheel) Schedule(key string, d time.Duration, fn func()) {
+	tw.Cancel(key)
+	if d < 0 {
+		d = 0
+	}
+
+	// round up, plus one because the current tick is already partly over: a timer never fires early
+	ticks := int((d+tw.tick-1)/tw.tick) + 1
+	t := &wheelTimer{
+		slot:   (tw.pos + ticks) % len(tw.slots),
+		rounds: (ticks - 1) / len(tw.slots),
+		fn:     fn,
+	}
+	tw.slots[t.slot][key] = t
+	tw.byKey[key] = t
+}
+
+func (tw *TimerWheel) Cancel(key string) {
+	if t, ok := tw.byKey[key]; ok {
+		delete(tw.slots[t.slot], key)
+		delete(tw.byKey, key)
+	}
+}
+
+func (tw *TimerWheel) Pending(key string) bool {
+	_, ok := tw.byKey[key]
+	return ok
+}
+
+// Advance moves the wheel by one tick and runs every timer that became due.
+func (tw *TimerWheel) Advance() {
+	tw.pos = (tw.pos + 1) % len(tw.slots)
+
+	type dueTimer struct {
+		key string
+		t   *wheelTimer
+	}
+	var due []dueTimer
+	for key, t := range tw.slots[tw.pos] {
+		if t.rounds > 0 {
+			t.rounds--
+			continue
+		}
+		delete(tw.slots[tw.pos], key)
+		due = append(due, dueTimer{key, t})
+	}
+
+	// callbacks run after the slot is cleaned so they can schedule again. A due timer stays in
+	// byKey until it runs: one callback may cancel or replace another that is due in this tick.
+	for _, d := range due {
+		if tw.byKey[d.key] != d.t {
+			continue
+		}
+		delete(tw.byKey, d.key)
+		d.t.fn()
+	}
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimerWheelFires(t *testing.T) {
+	tw := NewTimerWheel(time.Second, 4)
+	fired := 0
+	tw.Schedule("a", 6*time.Second, func() { fired++ }) // more than one lap
+
+	for range 6 {
+		tw.Advance()
+	}
+	if fired != 0 {
+		t.Fatal("fired early")
+	}
+	tw.Advance()
+	if fired != 1 || tw.Pending("a") {
+		t.Fatalf("fired %d times, pending %v", fired, tw.Pending("a"))
+	}
+}
+
+func TestTimerWheelCancelInCallback(t *testing.T) {
+	tw := NewTimerWheel(time.Second, 8)
+	var fired []string
+	// both due in the same tick, whichever runs first cancels the other
+	tw.Schedule("a", time.Second, func() { fired = append(fired, "a"); tw.Cancel("b") })
+	tw.Schedule("b", time.Second, func() { fired = append(fired, "b"); tw.Cancel("a") })
+
+	tw.Advance()
+	tw.Advance()
+	if len(fired) != 1 {
+		t.Fatalf("fired %v, want only one of them", fired)
+	}
+	if tw.Pending("a") || tw.Pending("b") {
+		t.Error("timer left behind")
+	}
+}
+
+func TestTimerWheelRescheduleInCallback(t *testing.T) {
+	tw := NewTimerWheel(time.Second, 8)
+	var fired []string
+	tw.Schedule("a", time.Second, func() {
+		fired = append(fired, "a")
+		tw.Schedule("b", 3*time.Second, func() { fired = append(fired, "b later") })
+	})
+	tw.Schedule("b", time.Second, func() {
+		fired = append(fired, "b")
+		tw.Schedule("a", 3*time.Second, func() { fired = append(fired, "a later") })
+	})
+
+	for range 6 {
+		tw.Advance()
+	}
+	// the first one replaced the other before it ran: two callbacks, not four
+	if len(fired) != 2 {
+		t.Fatalf("fired %v", fired)
+	}
+}
@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"sync"
+
+	"signaling-server-webrtc/pkg/sfu"
+	"signaling-server-webrtc/pkg/signal"
+	"signaling-server-webrtc/utils"
+)
+
+// room topologies, set with "topology" when the room is created
+const (
+	TopologyMesh = "mesh" // default, peers negotiate with each other through the hub
+	TopologySFU  = "sfu"  // every client negotiates with the server, which forwards media and data
+
+	TopologyBroadcast    = "broadcast"     // one presenter, receive-only viewers negotiating with the presenter
+	TopologyBroadcastSFU = "broadcast-sfu" // same, the viewers negotiate with the server instead
+)
+
+// SetSFU enables rooms with topology "sfu"
+func (h *Hub) SetSFU(s *sfu.SFU) {
+	h.sfu = s
+}
+
+func (h *Hub) SFUEnabled() bool {
+	return h.sfu != nil
+}
+
+func (h *Hub) topology(roomId string) string {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+
+	if opts := h.Options[roomId]; opts != nil && opts.Topology != "" {
+		return opts.Topology
+	}
+	return TopologyMesh
+}
+
+// usesSFU tells if the clients of the room negotiate with the server
+func (h *Hub) usesSFU(roomId string) bool {
+	if h.sfu == nil {
+		return false
+	}
+	t := h.topology(roomId)
+	return t == TopologySFU || t == TopologyBroadcastSFU
+}
+
+// replyFunc sends server generated messages to c through the hub, same as error replies
+func (h *Hub) replyFunc(c *Client) func([]byte) {
+	return func(data []byte) {
+		h.Broadcast <- MessageEnvelope{Sender: c, RoomID: c.RoomID, Data: data, Direct: true}
+	}
+}
+
+/*
+joinSFU / leaveSFU are called from Run and leave the work to sfuQueue: building a
+PeerConnection takes a while and Run must go on. One room's joins and leaves run one
+after the other in the order Run saw them, a Leave overtaking its Join would leak the peer.
+*/
+func (h *Hub) joinSFU(c *Client) {
+	viewer := h.isViewer(c)
+	h.sfuQueue.do(c.RoomID, func() {
+		if err := h.sfu.Join(c.RoomID, c.ClientId, h.replyFunc(c), viewer); err != nil {
+			utils.LogRoom(c.RoomID, c.ClientId, "SFU join failed: %s", err)
+			c.sendError(h, signal.NewError("sfu-join-failed", "%s", err), "")
+		}
+	})
+}
+
+func (h *Hub) leaveSFU(roomId, clientId string) {
+	h.sfuQueue.do(roomId, func() { h.sfu.Leave(roomId, clientId) })
+}
+
+// workQueue runs the jobs of each room in order, one goroutine per room while it has some
+type workQueue struct {
+	mu    sync.Mutex
+	rooms map[string][]func() // present while the room's goroutine runs
+}
+
+func (q *workQueue) do(roomId string, job func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.rooms == nil {
+		q.rooms = make(map[string][]func())
+	}
+	jobs, running := q.rooms[roomId]
+	q.rooms[roomId] = append(jobs, job)
+	if !running {
+		go q.run(roomId)
+	}
+}
+
+func (q *workQueue) run(roomId string) {
+	for {
+		q.mu.Lock()
+		jobs := q.rooms[roomId]
+		if len(jobs) == 0 {
+			delete(q.rooms, roomId)
+			q.mu.Unlock()
+			return
+		}
+		q.rooms[roomId] = jobs[1:]
+		q.mu.Unlock()
+
+		jobs[0]()
+	}
+}
+
+// toSFU hands the client's negotiation messages to the SFU, false means relay as usual
+func (h *Hub) toSFU(c *Client, raw []byte) bool {
+	if !h.usesSFU(c.RoomID) {
+		return false
+	}
+	msg, ok := signal.Parse(raw)
+	if !ok {
+		return false
+	}
+
+	handled, err := h.sfu.HandleMessage(c.RoomID, c.ClientId, msg)
+	if err != nil {
+		utils.LogRoom(c.RoomID, c.ClientId, "SFU refused %s: %s", msg.Type, err)
+		c.sendError(h, signal.NewError("sfu-negotiation-failed", "%s", err), msg.Type)
+	}
+	return handled
+}
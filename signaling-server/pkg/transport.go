@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+Transport carries one client's messages. The hub only deals with Clients, so peers on
+different transports talk as usual:
+
+  - a WebSocket (/ws)
+  - a Stream: Server-Sent Events down, POSTs up, for networks that block WebSocket upgrades, see stream.go
+
+Server side bots have none, they read Send and call HandleMessage themselves.
+*/
+type Transport interface {
+	Read() ([]byte, error) // next message from the client, an error once it is gone
+	Write(msg []byte) error
+	Close() error
+	interrupt() // makes a waiting Read return, for a handoff
+}
+
+type wsTransport struct {
+	conn *websocket.Conn
+}
+
+func (t wsTransport) Read() ([]byte, error) {
+	_, msg, err := t.conn.ReadMessage()
+	return msg, err
+}
+
+func (t wsTransport) Write(msg []byte) error {
+	return t.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (t wsTransport) Close() error {
+	return t.conn.Close()
+}
+
+// a socket from srv stops at the next message boundary, anything else right away
+func (t wsTransport) interrupt() {
+	if fc, ok := t.conn.NetConn().(*FrameConn); ok {
+		fc.Stop()
+		return
+	}
+	t.conn.SetReadDeadline(time.Now())
+}
+
+// webSocket is the client's connection if it came through /ws, only those can be handed off
+func (c *Client) webSocket() *websocket.Conn {
+	if t, ok := c.transport.(wsTransport); ok {
+		return t.conn
+	}
+	return nil
+}
+
+// resumable tells if the next process can read on the stopped socket, it did not stop mid frame
+func (c *Client) resumable() bool {
+	if fc, ok := c.webSocket().NetConn().(*FrameConn); ok {
+		return fc.Resumable()
+	}
+	return true
+}
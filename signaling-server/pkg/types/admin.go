@@ -0,0 +1,36 @@
+package types
+
+import "time"
+
+// AdminRoom is a room as the admin API shows it, with the state of every slot
+type AdminRoom struct {
+	RoomID       string        `json:"roomId"`
+	Options      RoomOptions   `json:"options"`
+	Presenter    string        `json:"presenter,omitempty"`
+	Private      bool          `json:"private,omitempty"`
+	Node         string        `json:"node,omitempty"` // created on
+	CreatedAt    time.Time     `json:"createdAt"`
+	WaitingSince *time.Time    `json:"waitingSince,omitempty"` // a client waits alone for a peer
+	Clients      []AdminClient `json:"clients"`
+}
+
+// client states
+const (
+	ClientConnected    = "connected"
+	ClientReserved     = "reserved"     // slot handed out, never connected (or its node is not known)
+	ClientReconnecting = "reconnecting" // dropped, its slot is held for RECONNECT_GRACE
+)
+
+type AdminClient struct {
+	ClientID string `json:"clientId"`
+	State    string `json:"state"`
+	Node     string `json:"node,omitempty"` // holding its socket
+	Role     string `json:"role,omitempty"` // presenter/viewer, offerer/answerer
+	Pending  int    `json:"pending,omitempty"`
+}
+
+// AdminAction is the body of close, kick and announce
+type AdminAction struct {
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
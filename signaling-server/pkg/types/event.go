@@ -0,0 +1,27 @@
+package types
+
+import "time"
+
+// Event is one thing the hub did, as /api/events streams it
+type Event struct {
+	Type     string         `json:"type"`
+	Time     time.Time      `json:"time"`
+	Node     string         `json:"node"`
+	RoomID   string         `json:"roomId,omitempty"`
+	ClientID string         `json:"clientId,omitempty"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// Event.Type
+const (
+	EventRoomCreated        = "room-created"        // data: topology, slots
+	EventRoomDeleted        = "room-deleted"        // data: reason (empty, closed, unused)
+	EventClientReserved     = "client-reserved"     // a slot was handed out by create/join/bots
+	EventClientConnected    = "client-connected"    // data: reconnected
+	EventClientDisconnected = "client-disconnected" // data: held (keeps its slot for RECONNECT_GRACE)
+	EventClientReleased     = "client-released"     // the slot is gone: left, kicked, grace over
+	EventRoleAssigned       = "role-assigned"       // data: role, presenter for viewers
+	EventTimeout            = "timeout"             // data: waitedSec, closed when the wait policy closed the room
+	EventRelay              = "relay"               // data: messages, bytes relayed since the last one, of any type
+	EventDropped            = "events-dropped"      // data: count, the subscriber was too slow
+)
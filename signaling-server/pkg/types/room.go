@@ -3,9 +3,10 @@ package types
 import "fmt"
 
 type Room struct {
-	RoomId   *string `json:"roomId,omitempty"`
-	ClientId *string `json:"clientId,omitempty"`
-	Status   *string `json:"status,omitempty" validate:"oneof=joined left created"`
+	RoomId     *string     `json:"roomId,omitempty"`
+	ClientId   *string     `json:"clientId,omitempty"`
+	Status     *string     `json:"status,omitempty" validate:"oneof=joined left created"`
+	IceServers []ICEServer `json:"iceServers,omitempty"`
 }
 
 // it ensure room and client are non empty
@@ -20,11 +21,62 @@ func (r *Room) ValidateLeaveRoom() error {
 }
 
 type RoomStats struct {
-	RoomID  string   `json:"roomId"`
-	Clients []string `json:"clients"`
+	RoomID  string      `json:"roomId"`
+	Clients []string    `json:"clients"`
+	Relay   *RelayUsage `json:"relay,omitempty"` // only with the embedded TURN server
+
+	// broadcast rooms only, Viewers counts the connected ones
+	Presenter string `json:"presenter,omitempty"`
+	Viewers   *int   `json:"viewers,omitempty"`
 }
 
 type HubStats struct {
 	TotalRooms int         `json:"totalRooms"`
 	Rooms      []RoomStats `json:"rooms"`
 }
+
+// RoomOptions are set once when the room is created (optional JSON body of /api/rooms/create)
+type RoomOptions struct {
+	WaitPolicy      *WaitPolicy `json:"waitPolicy,omitempty"`
+	CandidatePolicy string      `json:"candidatePolicy,omitempty"` // all | no-host | relay-only | mdns-only
+	Topology        string      `json:"topology,omitempty"`        // mesh (default) | sfu | broadcast | broadcast-sfu
+
+	Presenter string `json:"-"` // broadcast rooms: the creator's ClientId
+	Private   bool   `json:"-"` // nobody can join, all slots are handed out at creation (self-test)
+	Offerer   string `json:"-"` // fixed offerer, otherwise the lower ClientId
+}
+
+/*
+WaitPolicy decides what happens while a connected client waits alone in a room:
+
+	0s ---- FirstReminderSec ---- +RepeatEverySec ---- +RepeatEverySec ... CloseAfterSec
+	        "timeout" reminder     reminder             reminder            room closed
+
+A value of 0 disables that step.
+*/
+type WaitPolicy struct {
+	FirstReminderSec int `json:"firstReminderSec"`
+	RepeatEverySec   int `json:"repeatEverySec"`
+	CloseAfterSec    int `json:"closeAfterSec"`
+}
+
+func (p *WaitPolicy) Validate() error {
+	if p.FirstReminderSec < 0 || p.RepeatEverySec < 0 || p.CloseAfterSec < 0 {
+		return fmt.Errorf("waitPolicy values must not be negative")
+	}
+	return nil
+}
+
+// ICEServer has the shape of the browser's RTCIceServer so it can be passed to RTCPeerConnection as is
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// RelayUsage is what the embedded TURN relay did for a room
+type RelayUsage struct {
+	Allocations   int    `json:"allocations"` // currently open
+	BytesSent     uint64 `json:"bytesSent"`   // client -> peer, all allocations so far
+	BytesReceived uint64 `json:"bytesReceived"`
+}
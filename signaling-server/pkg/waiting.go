@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"fmt"
+	"time"
+
+	"signaling-server-webrtc/pkg/types"
+	"signaling-server-webrtc/utils"
+)
+
+// waitState tracks a room where a connected client has no peer yet
+type waitState struct {
+	since     time.Time
+	reminders int
+}
+
+func waitTimerKey(roomId string) string {
+	return "wait:" + roomId
+}
+
+// waitPolicy returns the room's own policy or the hub default
+func (h *Hub) waitPolicy(roomId string) types.WaitPolicy {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+
+	if opts := h.Options[roomId]; opts != nil && opts.WaitPolicy != nil {
+		return *opts.WaitPolicy
+	}
+	return h.defaultWait
+}
+
+// updateWaiting starts the wait timer when a client is alone in a room and cancels it
+// as soon as a peer is there (or nobody is left). Called on every register/unregister,
+// here or on another node; connected is the room's presence from the store. The timer
+// runs on the node holding the lonely client.
+func (h *Hub) updateWaiting(roomId string, connected map[string]string) {
+	lonely := false
+	if len(connected) == 1 {
+		for clientId := range connected {
+			lonely = h.GetClientFromRoom(roomId, clientId) != nil
+		}
+	}
+
+	if !lonely {
+		if _, ok := h.waiting[roomId]; ok {
+			delete(h.waiting, roomId)
+			h.timers.Cancel(waitTimerKey(roomId))
+			if len(connected) > 1 {
+				utils.LogRoom(roomId, "Nil", "⏱️ peer joined, wait timer cancelled")
+			}
+		}
+		return
+	}
+
+	if _, ok := h.waiting[roomId]; ok {
+		return // already waiting, keep the original start time
+	}
+	h.waiting[roomId] = &waitState{since: time.Now()}
+	h.scheduleWaitTimer(roomId)
+}
+
+// scheduleWaitTimer arms the next step of the policy: the next reminder or the auto close, whichever comes first
+func (h *Hub) scheduleWaitTimer(roomId string) {
+	state := h.waiting[roomId]
+	policy := h.waitPolicy(roomId)
+	elapsed := time.Since(state.since)
+
+	var next time.Duration
+	if state.reminders == 0 && policy.FirstReminderSec > 0 {
+		next = time.Duration(policy.FirstReminderSec) * time.Second
+	} else if state.reminders > 0 && policy.RepeatEverySec > 0 {
+		next = elapsed + time.Duration(policy.RepeatEverySec)*time.Second
+	}
+	if policy.CloseAfterSec > 0 {
+		closeAt := time.Duration(policy.CloseAfterSec) * time.Second
+		if next == 0 || closeAt < next {
+			next = closeAt
+		}
+	}
+	if next == 0 {
+		return // nothing left to do for this room
+	}
+
+	h.timers.Schedule(waitTimerKey(roomId), next-elapsed, func() { h.onWaitTimer(roomId) })
+}
+
+func (h *Hub) onWaitTimer(roomId string) {
+	state, ok := h.waiting[roomId]
+	if !ok {
+		return
+	}
+	policy := h.waitPolicy(roomId)
+	waited := int(time.Since(state.since).Seconds())
+
+	if policy.CloseAfterSec > 0 && waited >= policy.CloseAfterSec {
+		h.notifyConnected(roomId, []byte(fmt.Sprintf(
+			`{"type":"room-closed","message":"no peer joined in %d seconds, room closed"}`, waited)))
+		delete(h.waiting, roomId)
+		h.emit(types.EventTimeout, roomId, "", map[string]any{"waitedSec": waited, "closed": true})
+		h.closeRoom(roomId)
+		return
+	}
+
+	msg := fmt.Sprintf(`{"type":"timeout","message":"no peer joined in %d seconds"`, waited)
+	if policy.CloseAfterSec > 0 {
+		msg += fmt.Sprintf(`,"closesInSec":%d`, policy.CloseAfterSec-waited)
+	}
+	h.notifyConnected(roomId, []byte(msg+"}"))
+	utils.LogRoom(roomId, "Nil", "⏱️ no peer joined in %d seconds, reminder sent", waited)
+	h.emit(types.EventTimeout, roomId, "", map[string]any{"waitedSec": waited, "closed": false})
+
+	state.reminders++
+	h.scheduleWaitTimer(roomId)
+}
+
+// notifyConnected sends a server message to every connected client without blocking the hub
+func (h *Hub) notifyConnected(roomId string, msg []byte) {
+	for _, c := range h.connectedClients(roomId) {
+		h.sendDirect(c, msg)
+	}
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"errors"
+
+	"signaling-server-webrtc/pkg/sfu"
+	"signaling-server-webrtc/pkg/store"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+WHIP (ingest) and WHEP (playback) sessions are plain HTTP offer/answer with the SFU,
+for broadcast tools that do not speak our WebSocket protocol. They use the same
+room registry as everybody else: the room must have topology "sfu" and the client
+must hold a reservation in it (from create/join), its ClientId is the bearer token.
+*/
+
+var (
+	ErrRoomNotFound = store.ErrRoomNotFound
+	ErrNotReserved  = errors.New("client is not part of the room")
+	ErrNotSFURoom   = errors.New("room does not use the sfu topology")
+)
+
+// checkHTTPSession is the common gate of every WHIP/WHEP request
+func (h *Hub) checkHTTPSession(roomId, clientId string) error {
+	room, err := h.store.Lookup(roomId)
+	if err != nil {
+		return err
+	}
+	h.hydrate(roomId) // the SFU session needs the room's options on this node
+
+	switch {
+	case !room.Has(clientId):
+		return ErrNotReserved
+	case h.sfu == nil || h.topology(roomId) != TopologySFU:
+		return ErrNotSFURoom
+	}
+	return nil
+}
+
+// StartWHIP validates the publisher's offer like a relayed one and returns the SFU's answer
+func (h *Hub) StartWHIP(roomId, clientId, offer string) (string, error) {
+	return h.startHTTPSession(roomId, clientId, offer, true)
+}
+
+// StartWHEP returns the answer for a viewer playing the room program
+func (h *Hub) StartWHEP(roomId, clientId, offer string) (string, error) {
+	return h.startHTTPSession(roomId, clientId, offer, false)
+}
+
+func (h *Hub) startHTTPSession(roomId, clientId, offer string, publish bool) (string, error) {
+	if err := h.checkHTTPSession(roomId, clientId); err != nil {
+		return "", err
+	}
+	if _, err := h.sdpPolicy.Validate(offer); err != nil {
+		return "", err
+	}
+
+	if publish {
+		utils.LogRoom(roomId, clientId, "📡 WHIP publish")
+		return h.sfu.Publish(roomId, clientId, offer)
+	}
+	utils.LogRoom(roomId, clientId, "📺 WHEP play")
+	return h.sfu.Play(roomId, clientId, offer)
+}
+
+// TrickleHTTPSession adds the candidates of a PATCH (trickle-ice-sdpfrag) to the session
+func (h *Hub) TrickleHTTPSession(roomId, clientId, frag string) error {
+	if err := h.checkHTTPSession(roomId, clientId); err != nil {
+		return err
+	}
+	return h.sfu.Trickle(roomId, clientId, frag)
+}
+
+// StopHTTPSession ends a WHIP/WHEP session, the reservation stays until the room is gone
+func (h *Hub) StopHTTPSession(roomId, clientId string) error {
+	if err := h.checkHTTPSession(roomId, clientId); err != nil {
+		return err
+	}
+	if !h.sfu.HasSession(roomId, clientId) {
+		return sfu.ErrNoSession
+	}
+	utils.LogRoom(roomId, clientId, "WHIP/WHEP session ended")
+	h.sfu.Leave(roomId, clientId)
+	return nil
+}
@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+FrameConn sits between a WebSocket's socket and gorilla, so that a handoff stops
+reading between two messages. gorilla reads ahead into its own buffer, and what
+it holds is lost with the process; so each Read here ends at the end of the
+current frame at the latest. Once Stop is called, a Read at a message boundary
+fails instead of touching the socket: gorilla's buffer is empty then, and the
+next process starts reading right at the next frame.
+
+	[hdr|payload][hdr|payload][hdr|pay...
+	             ^ a Read never crosses this, Stop waits for one
+*/
+type FrameConn struct {
+	net.Conn
+
+	mu       sync.Mutex
+	stopping bool
+
+	// only touched by the reading goroutine
+	header     []byte // of the frame being read, until its length is known
+	left       int64  // bytes of the current frame still to read
+	fragmented bool   // the data message goes on in the next frame
+	extended   bool   // Stop caught us mid frame, the client got frameWait to finish it
+	broken     bool   // it did not, the socket is not at a frame boundary
+}
+
+// a client sending half a frame when the handoff starts gets this long to finish it
+const frameWait = 2 * time.Second
+
+var ErrHandedOff = errors.New("connection handed off")
+
+func NewFrameConn(conn net.Conn) *FrameConn {
+	return &FrameConn{Conn: conn}
+}
+
+// Stop makes Read fail at the next message boundary, waking up a waiting one
+func (c *FrameConn) Stop() {
+	c.mu.Lock()
+	c.stopping = true
+	c.mu.Unlock()
+	c.Conn.SetReadDeadline(time.Now())
+}
+
+// Resumable tells if the next process can read on, after the reader stopped
+func (c *FrameConn) Resumable() bool {
+	return !c.broken && c.atBoundary()
+}
+
+func (c *FrameConn) isStopping() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopping
+}
+
+func (c *FrameConn) atBoundary() bool {
+	return len(c.header) == 0 && c.left == 0 && !c.fragmented
+}
+
+func (c *FrameConn) Read(p []byte) (int, error) {
+	for {
+		if c.isStopping() && c.atBoundary() {
+			return 0, ErrHandedOff
+		}
+		n, err := c.Conn.Read(p[:c.want(len(p))])
+		c.consume(p[:n])
+
+		var netErr net.Error
+		if err == nil || !c.isStopping() || !errors.As(err, &netErr) || !netErr.Timeout() {
+			return n, err
+		}
+		if n > 0 || c.atBoundary() {
+			return n, nil // the loop or gorilla's next Read stops at the boundary
+		}
+		// mid frame: give the client a moment to send the rest
+		if c.extended {
+			c.broken = true
+			return 0, err
+		}
+		c.extended = true
+		c.Conn.SetReadDeadline(time.Now().Add(frameWait))
+	}
+}
+
+// File keeps the socket transferable, see srv.ConnFile
+func (c *FrameConn) File() (*os.File, error) {
+	if f, ok := c.Conn.(interface{ File() (*os.File, error) }); ok {
+		return f.File()
+	}
+	return nil, errors.New("connection has no file descriptor (TLS?)")
+}
+
+// want is how much of n may be read without going past the current frame
+func (c *FrameConn) want(n int) int {
+	if c.left > 0 {
+		return int(min(int64(n), c.left))
+	}
+	return min(n, frameHeaderLen(c.header)-len(c.header))
+}
+
+// consume follows the frames in what was read (RFC 6455 5.2)
+func (c *FrameConn) consume(b []byte) {
+	for len(b) > 0 {
+		if c.left > 0 {
+			k := min(int64(len(b)), c.left)
+			c.left -= k
+			b = b[k:]
+			continue
+		}
+
+		k := min(len(b), frameHeaderLen(c.header)-len(c.header))
+		c.header = append(c.header, b[:k]...)
+		b = b[k:]
+		if len(c.header) < frameHeaderLen(c.header) {
+			continue
+		}
+
+		h := c.header
+		switch length := h[1] & 0x7f; length {
+		case 126:
+			c.left = int64(binary.BigEndian.Uint16(h[2:4]))
+		case 127:
+			c.left = int64(binary.BigEndian.Uint64(h[2:10]))
+		default:
+			c.left = int64(length)
+		}
+		if opcode := h[0] & 0x0f; opcode < 8 { // control frames may come in the middle of a message
+			c.fragmented = h[0]&0x80 == 0
+		}
+		c.header = c.header[:0]
+	}
+}
+
+// frameHeaderLen is the header size, as far as the bytes so far tell
+func frameHeaderLen(h []byte) int {
+	if len(h) < 2 {
+		return 2
+	}
+	n := 2
+	switch h[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if h[1]&0x80 != 0 {
+		n += 4 // masking key, always there from a client
+	}
+	return n
+}
@@ -0,0 +1,139 @@
+package sdk
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"signaling-server-webrtc/pkg/types"
+)
+
+// Stats and the admin calls need Client.APIKey: operator to look, admin to act
+
+type AdminRoom = types.AdminRoom
+type AdminClient = types.AdminClient
+
+// AdminClient.State
+const (
+	ClientConnected    = types.ClientConnected
+	ClientReserved     = types.ClientReserved
+	ClientReconnecting = types.ClientReconnecting
+)
+
+// Stats lists every room with its client ids
+func (c *Client) Stats(ctx context.Context) (types.HubStats, error) {
+	var stats types.HubStats
+	err := c.call(ctx, http.MethodGet, "/api/rooms/stats", nil, &stats)
+	return stats, err
+}
+
+// AdminRooms lists every room with the state of each slot
+func (c *Client) AdminRooms(ctx context.Context) ([]AdminRoom, error) {
+	var resp struct {
+		Rooms []AdminRoom `json:"rooms"`
+	}
+	err := c.call(ctx, http.MethodGet, "/api/admin/rooms", nil, &resp)
+	return resp.Rooms, err
+}
+
+func (c *Client) AdminRoom(ctx context.Context, roomId string) (AdminRoom, error) {
+	var room AdminRoom
+	err := c.call(ctx, http.MethodGet, "/api/admin/rooms/"+url.PathEscape(roomId), nil, &room)
+	return room, err
+}
+
+// CloseRoom ends the room for everybody in it, reason may be empty for the server default
+func (c *Client) CloseRoom(ctx context.Context, roomId, reason string) error {
+	return c.adminAction(ctx, http.MethodDelete, "/api/admin/rooms/"+url.PathEscape(roomId), types.AdminAction{Reason: reason}, nil)
+}
+
+// Kick disconnects a client and releases its slot
+func (c *Client) Kick(ctx context.Context, roomId, clientId, reason string) error {
+	path := "/api/admin/rooms/" + url.PathEscape(roomId) + "/clients/" + url.PathEscape(clientId) + "/kick"
+	return c.adminAction(ctx, http.MethodPost, path, types.AdminAction{Reason: reason}, nil)
+}
+
+// Announce sends a message to the clients of one room, or of all rooms when roomId is empty. It returns the number of rooms.
+func (c *Client) Announce(ctx context.Context, roomId, message string) (int, error) {
+	path := "/api/admin/announce"
+	if roomId != "" {
+		path = "/api/admin/rooms/" + url.PathEscape(roomId) + "/announce"
+	}
+	var resp struct {
+		Rooms int `json:"rooms"`
+	}
+	err := c.adminAction(ctx, http.MethodPost, path, types.AdminAction{Message: message}, &resp)
+	return resp.Rooms, err
+}
+
+func (c *Client) adminAction(ctx context.Context, method, path string, action types.AdminAction, out any) error {
+	body, err := json.Marshal(action)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		out = &json.RawMessage{}
+	}
+	return c.call(ctx, method, path, body, out)
+}
+
+type Event = types.Event
+
+// Events streams the hub's events to fn until ctx ends, the server closes the stream or fn fails.
+// roomId "" and no eventTypes for everything.
+func (c *Client) Events(ctx context.Context, roomId string, eventTypes []string, fn func(Event) error) error {
+	q := url.Values{}
+	if roomId != "" {
+		q.Set("roomId", roomId)
+	}
+	if len(eventTypes) > 0 {
+		q.Set("types", strings.Join(eventTypes, ","))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/api/events?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	hc := *c.HTTP
+	hc.Timeout = 0 // the stream is open for good, ctx ends it
+	res, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(res.Body).Decode(&e)
+		return &HTTPError{Status: res.StatusCode, Message: e.Error}
+	}
+
+	// one "data:" line per event, comments (keep-alives) start with ':'
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return ctx.Err()
+}
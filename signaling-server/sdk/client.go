@@ -0,0 +1,165 @@
+/*
+Package sdk talks to the signaling server from Go: rooms over REST, signaling over
+the WebSocket with heartbeats and reconnect.
+
+	c := sdk.New("https://signal.example.com")
+	room, err := c.CreateRoom(ctx, nil)
+	conn, err := c.Connect(ctx, room)
+	defer conn.Close()
+
+	for {
+		msg, err := conn.Receive(ctx)
+		if err != nil {
+			return err
+		}
+		switch msg.Type {
+		case sdk.TypeRole:
+			role, _ := msg.Role()
+			...
+		}
+	}
+*/
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"signaling-server-webrtc/pkg/types"
+)
+
+// Client holds the server address and how connections behave, New fills in the defaults
+type Client struct {
+	BaseURL string // http(s)://host:port, the WebSocket URL is derived from it
+	HTTP    *http.Client
+	Dialer  *websocket.Dialer
+	APIKey  string // sent as Bearer token, needed for stats and the admin calls
+
+	Heartbeat      time.Duration // ping interval, the connection counts as dead after 2 missed pongs
+	Reconnect      bool          // redial with the same roomId/clientId when the socket drops
+	MaxBackoff     time.Duration // between reconnect attempts, doubling from 500ms
+	OnReconnect    func(attempt int)
+	OnDisconnected func(err error)
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTP:       &http.Client{Timeout: 10 * time.Second},
+		Dialer:     websocket.DefaultDialer,
+		Heartbeat:  20 * time.Second,
+		Reconnect:  true,
+		MaxBackoff: 10 * time.Second,
+	}
+}
+
+// Room is the reservation the server hands out, Connect needs it
+type Room = types.Room
+
+// HTTPError is a non 2xx answer of the REST API
+type HTTPError struct {
+	Status  int
+	Message string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("signaling server: %d %s", e.Status, e.Message)
+}
+
+// CreateRoom creates a room, opts may be nil for the server defaults
+func (c *Client) CreateRoom(ctx context.Context, opts *types.RoomOptions) (*Room, error) {
+	var body []byte
+	if opts != nil {
+		var err error
+		if body, err = json.Marshal(opts); err != nil {
+			return nil, err
+		}
+	}
+	var room Room
+	if err := c.call(ctx, http.MethodPost, "/api/rooms/create", body, &room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// JoinRoom reserves a slot in an existing room
+func (c *Client) JoinRoom(ctx context.Context, roomId string) (*Room, error) {
+	var room Room
+	if err := c.call(ctx, http.MethodPost, "/api/rooms/join?roomId="+url.QueryEscape(roomId), nil, &room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// ICEServers fetches fresh STUN/TURN servers for the reservation, TURN credentials expire
+func (c *Client) ICEServers(ctx context.Context, room *Room) ([]types.ICEServer, error) {
+	q := url.Values{}
+	if room != nil && room.RoomId != nil && room.ClientId != nil {
+		q.Set("roomId", *room.RoomId)
+		q.Set("clientId", *room.ClientId)
+	}
+	var resp struct {
+		ICEServers []types.ICEServer `json:"iceServers"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/api/ice-servers?"+q.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.ICEServers, nil
+}
+
+func (c *Client) call(ctx context.Context, method, path string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(res.Body).Decode(&e)
+		return &HTTPError{Status: res.StatusCode, Message: e.Error}
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// wsURL turns the base URL into the /ws endpoint of the reservation
+func (c *Client) wsURL(roomId, clientId string) (string, error) {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	wsScheme(u)
+	u.Path = strings.TrimRight(u.Path, "/") + "/ws"
+	u.RawQuery = url.Values{"roomId": {roomId}, "clientId": {clientId}}.Encode()
+	return u.String(), nil
+}
+
+func wsScheme(u *url.URL) {
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+}
@@ -0,0 +1,337 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrClosed       = errors.New("sdk: connection closed")
+	ErrSlotReleased = errors.New("sdk: the server released the room slot, join again")
+)
+
+const (
+	minBackoff   = 500 * time.Millisecond
+	writeTimeout = 10 * time.Second
+)
+
+/*
+Conn is the signaling WebSocket of one room reservation. A background goroutine reads
+the socket, answers for the heartbeat and, with Client.Reconnect, redials when the
+socket drops (the server keeps the slot for RECONNECT_GRACE). Messages sent while
+reconnecting wait for the new socket or for their context.
+
+Send and Receive are safe for concurrent use.
+*/
+type Conn struct {
+	RoomID   string
+	ClientID string
+
+	client   *Client
+	url      string
+	incoming chan Message
+
+	closeOnce sync.Once
+	closed    chan struct{} // Close was called
+	dead      chan struct{} // the read loop is over for good, err says why
+	err       error
+
+	mu    sync.Mutex
+	ws    *websocket.Conn // nil while reconnecting
+	ready chan struct{}   // closed while ws is usable
+
+	writeMu sync.Mutex // gorilla allows one writer at a time
+}
+
+// Connect opens the WebSocket of a reservation made by CreateRoom/JoinRoom
+func (c *Client) Connect(ctx context.Context, room *Room) (*Conn, error) {
+	if room == nil || room.RoomId == nil || room.ClientId == nil {
+		return nil, errors.New("sdk: room has no roomId/clientId")
+	}
+	u, err := c.wsURL(*room.RoomId, *room.ClientId)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &Conn{
+		RoomID:   *room.RoomId,
+		ClientID: *room.ClientId,
+		client:   c,
+		url:      u,
+		incoming: make(chan Message, 64),
+		closed:   make(chan struct{}),
+		dead:     make(chan struct{}),
+		ready:    make(chan struct{}),
+	}
+	ws, err := conn.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn.setSocket(ws)
+	go conn.run(ws)
+	return conn, nil
+}
+
+// Receive returns the next message from the server. After the connection is gone for good
+// it returns the reason (ErrClosed after Close).
+func (c *Conn) Receive(ctx context.Context) (Message, error) {
+	select {
+	case msg, ok := <-c.incoming:
+		if !ok {
+			return Message{}, c.err
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+// Send writes one message, waiting for a reconnect if the socket is down
+func (c *Conn) Send(ctx context.Context, msg Message) error {
+	for {
+		c.mu.Lock()
+		ws, ready := c.ws, c.ready
+		c.mu.Unlock()
+
+		if ws != nil {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				deadline = time.Now().Add(writeTimeout)
+			}
+			c.writeMu.Lock()
+			defer c.writeMu.Unlock()
+			ws.SetWriteDeadline(deadline)
+			return ws.WriteJSON(msg)
+		}
+
+		select {
+		case <-ready:
+		case <-c.dead:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// SendData encodes data into a message of msgType, e.g. SendData(ctx, sdk.TypeOffer, offer)
+func (c *Conn) SendData(ctx context.Context, msgType string, data any) error {
+	return c.SendTo(ctx, "", msgType, data)
+}
+
+// SendTo addresses a message to one client, only meaningful for the presenter of a broadcast room
+func (c *Conn) SendTo(ctx context.Context, to, msgType string, data any) error {
+	msg, err := NewMessage(msgType, data)
+	if err != nil {
+		return err
+	}
+	msg.To = to
+	return c.Send(ctx, msg)
+}
+
+// Relay sends an application payload through the server (rate limited, see RELAY_RATE)
+func (c *Conn) Relay(ctx context.Context, payload any) error {
+	return c.SendData(ctx, TypeRelay, payload)
+}
+
+// Close leaves the room: the server frees the slot after its reconnect grace
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+
+	c.mu.Lock()
+	ws := c.ws
+	c.mu.Unlock()
+	if ws == nil {
+		return nil
+	}
+	ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	return ws.Close()
+}
+
+func (c *Conn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// dial follows the 307 of a clustered server to the room's node, the WebSocket dialer does not.
+// Reconnects start over at c.url: the room may have moved when its node is gone.
+func (c *Conn) dial(ctx context.Context) (*websocket.Conn, error) {
+	target := c.url
+	for hops := 0; ; hops++ {
+		ws, res, err := c.client.Dialer.DialContext(ctx, target, nil)
+		if err != nil && res != nil {
+			switch res.StatusCode {
+			case http.StatusUnauthorized:
+				return nil, ErrSlotReleased // the server does not know the reservation (anymore)
+			case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+				if loc, lerr := res.Location(); lerr == nil && hops < 3 {
+					wsScheme(loc)
+					target = loc.String()
+					continue
+				}
+			}
+		}
+		return ws, err
+	}
+}
+
+func (c *Conn) setSocket(ws *websocket.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ws = ws
+	if ws != nil {
+		close(c.ready)
+	} else {
+		c.ready = make(chan struct{})
+	}
+}
+
+// run owns the socket: reads it until it dies, then reconnects or gives up
+func (c *Conn) run(ws *websocket.Conn) {
+	for {
+		err := c.readLoop(ws)
+		c.setSocket(nil)
+		ws.Close()
+
+		if c.isClosed() {
+			c.finish(ErrClosed)
+			return
+		}
+		if c.client.OnDisconnected != nil {
+			c.client.OnDisconnected(err)
+		}
+		if !c.client.Reconnect {
+			c.finish(err)
+			return
+		}
+
+		ws, err = c.redial()
+		if err != nil {
+			c.finish(err)
+			return
+		}
+		c.setSocket(ws)
+		if c.isClosed() { // Close came in while dialing, it did not see this socket
+			ws.Close()
+		}
+	}
+}
+
+func (c *Conn) finish(err error) {
+	c.err = err
+	close(c.dead)
+	close(c.incoming)
+}
+
+// readLoop delivers messages until the socket fails or misses two heartbeats
+func (c *Conn) readLoop(ws *websocket.Conn) error {
+	timeout := 2 * c.client.Heartbeat
+	if c.client.Heartbeat > 0 {
+		ws.SetReadDeadline(time.Now().Add(timeout))
+		ws.SetPongHandler(func(string) error {
+			return ws.SetReadDeadline(time.Now().Add(timeout))
+		})
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go c.ping(ws, stop)
+	}
+
+	for {
+		_, raw, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if c.client.Heartbeat > 0 {
+			ws.SetReadDeadline(time.Now().Add(timeout))
+		}
+
+		var msg Message
+		if json.Unmarshal(raw, &msg) != nil {
+			msg = Message{Data: mustJSON(string(raw))} // untyped text from an old client, Type stays ""
+		}
+
+		select {
+		case c.incoming <- msg:
+		case <-c.closed:
+			return ErrClosed
+		}
+	}
+}
+
+func (c *Conn) ping(ws *websocket.Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(c.client.Heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// WriteControl may run next to the other writes
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// redial retries with a doubling backoff until it works, the slot is gone or Close is called
+func (c *Conn) redial() (*websocket.Conn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backoff := minBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ErrClosed
+		}
+
+		ws, err := c.dial(ctx)
+		if err == nil {
+			if c.client.OnReconnect != nil {
+				c.client.OnReconnect(attempt)
+			}
+			return ws, nil
+		}
+		if errors.Is(err, ErrSlotReleased) {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ErrClosed
+		}
+
+		backoff *= 2
+		if c.client.MaxBackoff > 0 && backoff > c.client.MaxBackoff {
+			backoff = c.client.MaxBackoff
+		}
+	}
+}
+
+func mustJSON(v any) json.RawMessage {
+	b, _ := json.Marshal(v)
+	return b
+}
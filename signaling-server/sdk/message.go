@@ -0,0 +1,126 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"signaling-server-webrtc/pkg/types"
+)
+
+// message types of the protocol, see API_DOC.md
+const (
+	TypeRole          = "role"
+	TypeOffer         = "offer"
+	TypeAnswer        = "answer"
+	TypeCandidate     = "candidate"
+	TypeRelay         = "relay"
+	TypeError         = "error"
+	TypeTimeout       = "timeout"
+	TypeRoomClosed    = "room-closed"
+	TypeViewerJoined  = "viewer-joined"
+	TypeViewerLeft    = "viewer-left"
+	TypePresenterLeft = "presenter-left"
+)
+
+/*
+Message is one frame of the WebSocket. Data stays raw, the typed accessors below
+decode it. Message/ClosesInSec are only set on server notices (timeout, room-closed).
+*/
+type Message struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+	To   string          `json:"to,omitempty"`
+	From string          `json:"from,omitempty"`
+
+	Message     string `json:"message,omitempty"`
+	ClosesInSec int    `json:"closesInSec,omitempty"`
+}
+
+// SessionDescription has the shape of RTCSessionDescriptionInit (and of pion's webrtc.SessionDescription)
+type SessionDescription struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// Candidate has the shape of RTCIceCandidateInit (and of pion's webrtc.ICECandidateInit)
+type Candidate struct {
+	Candidate        string  `json:"candidate"`
+	SDPMid           *string `json:"sdpMid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdpMLineIndex,omitempty"`
+	UsernameFragment *string `json:"usernameFragment,omitempty"`
+}
+
+type Role struct {
+	Role      string `json:"role"`                // offerer | answerer | presenter | viewer
+	Presenter string `json:"presenter,omitempty"` // viewers of a broadcast room
+}
+
+// ServerError is the data of an "error" message: the server refused a message of type For
+type ServerError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	For     string `json:"for"`
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server refused %s: %s %s", e.For, e.Code, e.Message)
+}
+
+// NewMessage encodes data into a message of the given type
+func NewMessage(msgType string, data any) (Message, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{Type: msgType, Data: raw}, nil
+}
+
+// Decode unmarshals Data into v
+func (m Message) Decode(v any) error {
+	return json.Unmarshal(m.Data, v)
+}
+
+func (m Message) Role() (Role, error) {
+	var r Role
+	return r, m.decodeAs(TypeRole, &r)
+}
+
+// SessionDescription decodes an offer or an answer
+func (m Message) SessionDescription() (SessionDescription, error) {
+	var d SessionDescription
+	if m.Type != TypeOffer && m.Type != TypeAnswer {
+		return d, fmt.Errorf("sdk: %q is not an offer/answer", m.Type)
+	}
+	return d, m.Decode(&d)
+}
+
+func (m Message) Candidate() (Candidate, error) {
+	var c Candidate
+	return c, m.decodeAs(TypeCandidate, &c)
+}
+
+func (m Message) Error() (*ServerError, error) {
+	var e ServerError
+	return &e, m.decodeAs(TypeError, &e)
+}
+
+// ClientID is the clientId carried by viewer-joined / viewer-left
+func (m Message) ClientID() (string, error) {
+	var d struct {
+		ClientId string `json:"clientId"`
+	}
+	err := m.Decode(&d)
+	return d.ClientId, err
+}
+
+func (m Message) decodeAs(msgType string, v any) error {
+	if m.Type != msgType {
+		return fmt.Errorf("sdk: %q is not a %s message", m.Type, msgType)
+	}
+	return m.Decode(v)
+}
+
+// RoomOptions is re-exported so callers do not need the server packages
+type RoomOptions = types.RoomOptions
+type WaitPolicy = types.WaitPolicy
+type ICEServer = types.ICEServer
@@ -0,0 +1,146 @@
+package srv
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/types"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+The hub's events for observers, as Server-Sent Events (GET /api/events) or over a
+WebSocket (GET /api/events/ws), one JSON types.Event per message:
+
+	?roomId=abc123                           only this room
+	?types=client-connected,role-assigned    only these types
+*/
+
+const eventsKeepAlive = 15 * time.Second
+
+func subscribe(hub *pkg.Hub, r *http.Request) *pkg.Subscription {
+	var eventTypes []string
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			eventTypes = append(eventTypes, t)
+		}
+	}
+	return hub.Subscribe(r.URL.Query().Get("roomId"), eventTypes...)
+}
+
+// dropNotice tells the subscriber how many events it missed, if any
+func dropNotice(sub *pkg.Subscription) *types.Event {
+	n := sub.TakeDropped()
+	if n == 0 {
+		return nil
+	}
+	return &types.Event{Type: types.EventDropped, Time: time.Now(), Data: map[string]any{"count": n}}
+}
+
+func ServeEventsSSE(hub *pkg.Hub, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.WriteError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	sub := subscribe(hub, r)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx would hold the events back
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	write := func(e types.Event) error {
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "data: %s\n\n", raw)
+		return err
+	}
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return // server shutting down
+			}
+			if notice := dropNotice(sub); notice != nil {
+				write(*notice)
+			}
+			if err := write(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func ServeEventsWS(hub *pkg.Hub, w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := subscribe(hub, r)
+	defer sub.Close()
+
+	// nothing is expected from the observer, reading only notices it leaving and answers pings
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(time.Second))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if notice := dropNotice(sub); notice != nil {
+				conn.WriteJSON(notice)
+			}
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package srv
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/bus"
+	"signaling-server-webrtc/pkg/store"
+	"signaling-server-webrtc/pkg/types"
+)
+
+func dial(t *testing.T, server *httptest.Server, roomId, clientId string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?roomId=" + roomId + "&clientId=" + clientId
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// handOff moves the rooms of old to a new hub the way main's handoff does, through duplicated descriptors
+func handOff(t *testing.T, old *pkg.Hub) *pkg.Hub {
+	t.Helper()
+	ho := old.Detach()
+	conns := make([]*websocket.Conn, len(ho.Conns))
+	for i, c := range ho.Conns {
+		f, err := ConnFile(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close() // the old process exits, its copy of the socket goes away
+		nc, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conns[i], err = ResumeWS(nc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := pkg.NewHub(store.NewMemory(), bus.NewLocal().Join("new"))
+	h.Restore(ho.Snapshot, conns)
+	go h.Run()
+	return h
+}
+
+func TestHandoffWhileSending(t *testing.T) {
+	old := pkg.NewHub(store.NewMemory(), bus.NewLocal().Join("old"))
+	go old.Run()
+	if err := old.CreateRoom("r1", types.RoomOptions{}, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ServeWS(old, w, r) }))
+	defer server.Close()
+
+	a, b := dial(t, server, "r1", "a"), dial(t, server, "r1", "b")
+
+	// a sends as fast as it can, messages big enough to be split across reads
+	padding := strings.Repeat("x", 3000)
+	stop, sent := make(chan struct{}), make(chan int, 1)
+	go func() {
+		n := 0
+		defer func() { sent <- n }()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := a.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("%d %s", n, padding))); err != nil {
+				t.Errorf("a: write %d: %s", n, err)
+				return
+			}
+			n++
+		}
+	}()
+
+	// b reads all along, like a browser: every message once, in order
+	var received atomic.Int64
+	var readErr atomic.Value
+	go func() {
+		for {
+			_, msg, err := b.ReadMessage()
+			if err != nil {
+				readErr.Store(err.Error())
+				return
+			}
+			var n int
+			if _, err := fmt.Sscanf(string(msg), "%d ", &n); err != nil {
+				continue // role and other server messages
+			}
+			if want := received.Load(); int64(n) != want {
+				readErr.Store(fmt.Sprintf("got message %d, want %d", n, want))
+				return
+			}
+			received.Add(1)
+		}
+	}()
+	expect := func(count int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); received.Load() < int64(count); time.Sleep(time.Millisecond) {
+			if err := readErr.Load(); err != nil {
+				t.Fatalf("b: after %d messages: %s", received.Load(), err)
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("b: %d messages, want %d", received.Load(), count)
+			}
+		}
+	}
+
+	expect(200)
+	handOff(t, old)
+	expect(400)
+
+	close(stop)
+	expect(<-sent)
+}
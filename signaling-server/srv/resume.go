@@ -0,0 +1,80 @@
+package srv
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/websocket"
+
+	"signaling-server-webrtc/pkg"
+)
+
+// a valid but meaningless key, the handshake answer never reaches the client
+const resumeKey = "AAAAAAAAAAAAAAAAAAAAAA=="
+
+/*
+ResumeWS wraps a WebSocket inherited from the previous process. gorilla only builds a
+server side *websocket.Conn through Upgrade, so it gets a fake request and a hijacker
+that swallows the 101 response: the client finished its handshake long ago.
+*/
+func ResumeWS(conn net.Conn) (*websocket.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodGet,
+		Header: http.Header{
+			"Connection":            {"Upgrade"},
+			"Upgrade":               {"websocket"},
+			"Sec-Websocket-Version": {"13"},
+			"Sec-Websocket-Key":     {resumeKey},
+		},
+	}
+	return upgrader.Upgrade(&resumeWriter{conn: pkg.NewFrameConn(&resumedConn{Conn: conn})}, req, nil)
+}
+
+// ConnFile returns a duplicate descriptor of a WebSocket's connection, to hand it to the next process
+func ConnFile(conn net.Conn) (*os.File, error) {
+	if f, ok := conn.(interface{ File() (*os.File, error) }); ok {
+		return f.File()
+	}
+	return nil, errors.New("connection has no file descriptor (TLS?)")
+}
+
+type resumeWriter struct {
+	conn   net.Conn
+	header http.Header
+}
+
+func (w *resumeWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *resumeWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *resumeWriter) WriteHeader(int)             {}
+
+func (w *resumeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+// resumedConn drops the first write, Upgrade's handshake response
+type resumedConn struct {
+	net.Conn
+	answered bool
+}
+
+func (c *resumedConn) Write(b []byte) (int, error) {
+	if !c.answered {
+		c.answered = true
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+// File keeps a resumed socket transferable at the next handoff
+func (c *resumedConn) File() (*os.File, error) {
+	return ConnFile(c.Conn)
+}
@@ -1,28 +1,47 @@
 package srv
 
 import (
+	"errors"
 	"fmt"
 
 	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/pkg/signal"
+	"signaling-server-webrtc/pkg/store"
 	"signaling-server-webrtc/pkg/types"
 	"signaling-server-webrtc/utils"
 )
 
 // only client A can create a room
-func CreateRoom(hub *pkg.Hub) (types.Room, error) {
-	roomId := utils.GenerateShortID()
-	clientId := utils.GenerateShortID()
-
-	hub.Mu.Lock()
-	defer hub.Mu.Unlock()
+func CreateRoom(hub *pkg.Hub, opts types.RoomOptions) (types.Room, error) {
+	if opts.WaitPolicy != nil {
+		if err := opts.WaitPolicy.Validate(); err != nil {
+			return types.Room{}, err
+		}
+	}
+	if !signal.CandidatePolicy(opts.CandidatePolicy).Valid() {
+		return types.Room{}, fmt.Errorf("unknown candidatePolicy %q", opts.CandidatePolicy)
+	}
+	switch opts.Topology {
+	case "", pkg.TopologyMesh, pkg.TopologyBroadcast:
+	case pkg.TopologySFU, pkg.TopologyBroadcastSFU:
+		if !hub.SFUEnabled() {
+			return types.Room{}, fmt.Errorf("sfu rooms are disabled on this server")
+		}
+	default:
+		return types.Room{}, fmt.Errorf("unknown topology %q", opts.Topology)
+	}
 
-	if hub.Rooms[roomId] == nil {
-		hub.Rooms[roomId] = make(map[string]*pkg.Client)
+	clientId := utils.GenerateShortID()
+	if opts.Topology == pkg.TopologyBroadcast || opts.Topology == pkg.TopologyBroadcastSFU {
+		opts.Presenter = clientId // the creator presents, everyone joining is a viewer
 	}
 
 	// adding placeholder client until WS Connects
-	hub.Rooms[roomId][clientId] = nil
 	// actual client object will be formed when WS connection is made to connect
+	roomId, err := createWithFreshID(hub, opts, clientId)
+	if err != nil {
+		return types.Room{}, err
+	}
 
 	utils.LogRoom(roomId, clientId, "room created placeholder client attached")
 
@@ -33,20 +52,47 @@ func CreateRoom(hub *pkg.Hub) (types.Room, error) {
 	}, nil
 }
 
+// CreatePrivateRoom makes a room nobody can join with two slots: the caller's (offerer) and peerId's
+func CreatePrivateRoom(hub *pkg.Hub) (room types.Room, peerId string, err error) {
+	clientId := utils.GenerateShortID()
+	peerId = utils.GenerateShortID()
+
+	roomId, err := createWithFreshID(hub, types.RoomOptions{Private: true, Offerer: clientId}, clientId, peerId)
+	if err != nil {
+		return types.Room{}, "", err
+	}
+
+	utils.LogRoom(roomId, clientId, "private room created, peer %s", peerId)
+
+	return types.Room{
+		RoomId:   &roomId,
+		ClientId: &clientId,
+		Status:   utils.Ptr("created"),
+	}, peerId, nil
+}
+
 // client B,C,... will join the room created by client A
 func JoinRoom(hub *pkg.Hub, roomId string) (types.Room, error) {
-	hub.Mu.Lock()
-	defer hub.Mu.Unlock()
-
-	if _, exists := hub.Rooms[roomId]; !exists {
+	room, err := hub.LookupRoom(roomId)
+	if errors.Is(err, store.ErrRoomNotFound) {
 		return types.Room{}, fmt.Errorf("invalid room id! room doesn't exist")
 	}
+	if err != nil {
+		return types.Room{}, err
+	}
+	if room.Options.Private {
+		return types.Room{}, fmt.Errorf("room is private")
+	}
 
 	clientId := utils.GenerateShortID()
 
 	// if room exist add a placeholder value for client in a room.
 	// will be updated in WS connection
-	hub.Rooms[roomId][clientId] = nil
+	if err := hub.ReserveSlot(roomId, clientId); errors.Is(err, store.ErrRoomNotFound) {
+		return types.Room{}, fmt.Errorf("invalid room id! room doesn't exist") // closed in the meantime
+	} else if err != nil {
+		return types.Room{}, err
+	}
 
 	utils.LogRoom(roomId, clientId, "Client joined room (placeholder)")
 
@@ -57,6 +103,20 @@ func JoinRoom(hub *pkg.Hub, roomId string) (types.Room, error) {
 	}, nil
 }
 
+// createWithFreshID stores the room under a new short id, drawing again on the rare collision
+func createWithFreshID(hub *pkg.Hub, opts types.RoomOptions, clientIds ...string) (string, error) {
+	for {
+		roomId := utils.GenerateShortID()
+		if !hub.OwnsRoomID(roomId) {
+			continue // it would hash to another node of the cluster
+		}
+		err := hub.CreateRoom(roomId, opts, clientIds...)
+		if !errors.Is(err, store.ErrRoomExists) {
+			return roomId, err
+		}
+	}
+}
+
 /*
 This will be deleted. The leave room will be called in websocket connection only.
 */
@@ -0,0 +1,130 @@
+package srv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"signaling-server-webrtc/pkg"
+	"signaling-server-webrtc/utils"
+)
+
+/*
+Signaling without WebSockets, for proxies that block the upgrade:
+
+	GET  /api/rooms/{roomId}/messages?clientId=...   Server-Sent Events, what /ws would send
+	POST /api/rooms/{roomId}/messages?clientId=...   one message, what the client would send on /ws
+
+The GET is the connection: the client is connected while it is open, and the
+EventSource reconnecting takes the slot back within RECONNECT_GRACE like a new /ws.
+*/
+
+const maxPostedMessage = 1 << 20 // above SDP_MAX_BYTES and RELAY_MAX_BYTES, they give the real answer
+
+var (
+	streamsClosed = make(chan struct{})
+	closeStreams  sync.Once
+)
+
+// CloseStreams ends every SSE signaling stream, on shutdown so the server does not wait for them.
+// Their clients keep the slot for the reconnect grace and come back to the next process.
+func CloseStreams() {
+	closeStreams.Do(func() { close(streamsClosed) })
+}
+
+func ServeStream(hub *pkg.Hub, w http.ResponseWriter, r *http.Request) {
+	roomID := mux.Vars(r)["roomId"]
+	clientId := r.URL.Query().Get("clientId")
+
+	if roomID == "" || clientId == "" {
+		http.Error(w, "Missing roomId or clientId", http.StatusBadRequest)
+		return
+	}
+	if !hub.IsReserved(roomID, clientId) {
+		http.Error(w, "Unauthorized: Invalid room or client ID", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.WriteError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	client, stream := pkg.NewStreamClient(roomID, clientId)
+	defer stream.Close() // the client's ReadPump unregisters it
+
+	hub.Register <- client
+	client.Start(hub)
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case msg := <-stream.Messages():
+			// a multi line message becomes several data lines, EventSource joins them again
+			for _, line := range strings.Split(strings.TrimRight(string(msg), "\r\n"), "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			if _, err := fmt.Fprint(w, "\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-stream.Done():
+			return // kicked, room closed or replaced by a newer connection
+		case <-r.Context().Done():
+			return
+		case <-streamsClosed:
+			return
+		}
+	}
+}
+
+func PostMessage(hub *pkg.Hub, w http.ResponseWriter, r *http.Request) {
+	roomID := mux.Vars(r)["roomId"]
+	clientId := r.URL.Query().Get("clientId")
+
+	msg, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPostedMessage))
+	if err != nil {
+		utils.WriteError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if len(msg) == 0 {
+		utils.WriteError(w, http.StatusBadRequest, "empty message")
+		return
+	}
+
+	// the stream may be held by another instance, the hub forwards it there
+	if err := hub.PostMessage(r.Context(), roomID, clientId, msg); err != nil {
+		if errors.Is(err, pkg.ErrNoStream) {
+			utils.WriteError(w, http.StatusNotFound, "no open message stream for this client, GET the stream first")
+			return
+		}
+		if errors.Is(err, pkg.ErrStreamClosed) {
+			utils.WriteError(w, http.StatusGone, "message stream closed")
+			return
+		}
+		utils.WriteError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	// accepted, a refusal (SDP policy, relay limits, ...) arrives on the stream as an "error" message
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,156 @@
+package srv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"signaling-server-webrtc/utils"
+)
+
+/*
+TLSSetup is what main needs to terminate TLS on the signaling server itself.
+
+Modes:
+  - "file": certificate + key are read from disk (TLS_CERT_FILE / TLS_KEY_FILE)
+    and can be re-read on SIGHUP through Reloader.
+  - "acme": certificates are obtained and renewed by autocert from the
+    directory at ACME_DIRECTORY_URL (Let's Encrypt by default, Pebble for local tests).
+*/
+type TLSSetup struct {
+	Config   *tls.Config
+	Reloader *CertReloader     // only set in "file" mode
+	ACME     *autocert.Manager // only set in "acme" mode
+}
+
+func NewTLSSetup(mode string) (*TLSSetup, error) {
+	switch mode {
+	case "file":
+		certFile := utils.GetEnv("TLS_CERT_FILE")
+		if certFile == "" {
+			certFile = "cert.pem"
+		}
+		keyFile := utils.GetEnv("TLS_KEY_FILE")
+		if keyFile == "" {
+			keyFile = "key.pem"
+		}
+
+		reloader, err := NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &TLSSetup{
+			Config:   &tls.Config{GetCertificate: reloader.GetCertificate},
+			Reloader: reloader,
+		}, nil
+
+	case "acme":
+		manager, err := newACMEManager()
+		if err != nil {
+			return nil, err
+		}
+		return &TLSSetup{
+			Config: manager.TLSConfig(),
+			ACME:   manager,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown TLS mode %q, must be 'file' or 'acme'", mode)
+	}
+}
+
+// CertReloader keeps the certificate in memory and swaps it on Reload.
+// Already established connections keep working, only new handshakes see the new cert.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Reload reads the cert and key from disk again. On error the old certificate stays in use.
+func (cr *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate %s / %s: %w", cr.certFile, cr.keyFile, err)
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.mu.Unlock()
+
+	log.Printf("TLS certificate loaded from %s", cr.certFile)
+	return nil
+}
+
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+/*
+newACMEManager builds the autocert manager from env:
+
+  - ACME_DOMAINS       comma separated list of host names we are allowed to get certs for (required)
+  - ACME_DIRECTORY_URL ACME directory, defaults to Let's Encrypt production
+  - ACME_CA_BUNDLE     PEM file to trust when talking to the directory (Pebble uses a self signed one)
+  - ACME_CACHE_DIR     where issued certs are kept between restarts, defaults to "certs"
+  - ACME_EMAIL         contact address for the account
+*/
+func newACMEManager() (*autocert.Manager, error) {
+	domains := utils.GetEnvList("ACME_DOMAINS")
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("'ACME_DOMAINS' environment variable not set")
+	}
+
+	cacheDir := utils.GetEnv("ACME_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = "certs"
+	}
+
+	client := &acme.Client{DirectoryURL: autocert.DefaultACMEDirectory}
+	if dir := utils.GetEnv("ACME_DIRECTORY_URL"); dir != "" {
+		client.DirectoryURL = dir
+	}
+
+	if bundle := utils.GetEnv("ACME_CA_BUNDLE"); bundle != "" {
+		pem, err := os.ReadFile(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading ACME CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ACME CA bundle %s", bundle)
+		}
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		}
+	}
+
+	log.Printf("ACME enabled for %v using %s", domains, client.DirectoryURL)
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      utils.GetEnv("ACME_EMAIL"),
+		Client:     client,
+	}, nil
+}
@@ -1,10 +1,10 @@
 package srv
 
 import (
-	"fmt"
+	"bufio"
 	"log"
+	"net"
 	"net/http"
-	"time"
 
 	"github.com/gorilla/websocket"
 
@@ -26,59 +26,41 @@ func ServeWS(hub *pkg.Hub, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hub.Mu.RLock()
-	room, roomExists := hub.Rooms[roomID]
-	_, clientExistsInRoom := room[clientId]
-	hub.Mu.RUnlock()
-
-	if !roomExists || !clientExistsInRoom {
+	if !hub.IsReserved(roomID, clientId) {
 		http.Error(w, "Unauthorized: Invalid room or client ID", http.StatusUnauthorized)
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(frameWriter{w}, r, nil)
 	if err != nil {
 		log.Println("Upgrade error:", err)
 		return
 	}
 
-	client := &pkg.Client{
-		Connection: conn,
-		ClientId:   clientId,
-		RoomID:     roomID,
-		Send:       make(chan []byte, 256),
-	}
+	client := pkg.NewClient(conn, roomID, clientId)
 
 	hub.Register <- client // register the client
 
 	// these are Per-client goroutines
-	go client.WritePump()
-	go client.ReadPump(hub)
+	client.Start(hub)
 
-	// notify first peer if nobody joins in time
-	const WAIT_TIME = 1 * time.Minute
-	go func(roomID, clientId string) {
-		time.Sleep(WAIT_TIME)
-
-		clientPtr := hub.GetClientFromRoom(roomID, clientId)
-		if clientPtr == nil {
-			log.Printf("[Room:%s] [Client:%s] Client no longer exists, skipping timeout", roomID, clientId)
-			return
-		}
+	// waiting for a peer (reminders, auto close) is handled by the hub timer wheel
+}
 
-		stats := hub.RoomStats(roomID)
-		if len(stats.Clients) < 2 {
-			timeOutMsg := []byte(fmt.Sprintf(`{
-				"type":"timeout", 
-				"message": "no peer joined in %d seconds"
-			}`, int(WAIT_TIME.Seconds())))
+// frameWriter hands gorilla the socket wrapped in a pkg.FrameConn, so a handoff stops reading between messages
+type frameWriter struct {
+	http.ResponseWriter
+}
 
-			select {
-			case clientPtr.Send <- timeOutMsg:
-				log.Printf("[Room:%s] [Client:%s] No peer joined in time, notifying client", roomID, clientId)
-			default:
-				log.Printf("[Room:%s] [Client:%s] Cannot send timeout - channel unavailable", roomID, clientId)
-			}
-		}
-	}(roomID, clientId)
+func (w frameWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil || brw.Reader.Buffered() > 0 {
+		return conn, brw, err // gorilla refuses data sent before the handshake anyway
+	}
+	fc := pkg.NewFrameConn(conn)
+	return fc, bufio.NewReadWriter(bufio.NewReader(fc), brw.Writer), nil
 }
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"signaling-server-webrtc/pkg/types"
 )
@@ -22,3 +24,13 @@ func DecodeRoomRequest(r *http.Request) (types.Room, error) {
 	err := json.NewDecoder(r.Body).Decode(&room)
 	return room, err
 }
+
+// DecodeRoomOptions reads the optional create room body, an empty body means default options
+func DecodeRoomOptions(r *http.Request) (types.RoomOptions, error) {
+	var opts types.RoomOptions
+	err := json.NewDecoder(r.Body).Decode(&opts)
+	if errors.Is(err, io.EOF) {
+		return opts, nil
+	}
+	return opts, err
+}
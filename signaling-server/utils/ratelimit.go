@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket refilled at rate per second up to burst. Safe for concurrent use.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate, burst float64) *RateLimiter {
+	return &RateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Allow takes cost tokens if there are enough of them
+func (l *RateLimiter) Allow(cost float64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < cost {
+		return false
+	}
+	l.tokens -= cost
+	return true
+}
@@ -6,6 +6,9 @@ import (
 	"math/big"
 	mrand "math/rand"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
@@ -42,3 +45,42 @@ func GetEnv(name string) string {
 	}
 	return ""
 }
+
+// GetEnvDuration parses a duration env variable like "90s" or "5m", falling back to def when unset or invalid
+func GetEnvDuration(name string, def time.Duration) time.Duration {
+	value := GetEnv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Printf("[WARN] invalid duration %q for %s, using %v\n", value, name, def)
+		return def
+	}
+	return d
+}
+
+// GetEnvInt parses an integer env variable, falling back to def when unset or invalid
+func GetEnvInt(name string, def int) int {
+	value := GetEnv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("[WARN] invalid number %q for %s, using %v\n", value, name, def)
+		return def
+	}
+	return n
+}
+
+// GetEnvList splits a comma separated env variable, entries are trimmed and empty ones skipped
+func GetEnvList(name string) []string {
+	var list []string
+	for _, item := range strings.Split(GetEnv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}